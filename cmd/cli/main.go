@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 	"trae-proxy-go/internal/autoconfig"
 	"trae-proxy-go/internal/cert"
 	"trae-proxy-go/internal/config"
@@ -45,6 +46,8 @@ func main() {
 		handleStart()
 	case "doctor":
 		handleDoctor()
+	case "usage":
+		handleUsage()
 	default:
 		fmt.Fprintf(os.Stderr, "未知命令: %s\n", command)
 		printUsage()
@@ -65,6 +68,7 @@ func printUsage() {
 	fmt.Println("  cert                   生成证书")
 	fmt.Println("  start                  启动代理服务器")
 	fmt.Println("  doctor                 检测代理/端口冲突并给出建议")
+	fmt.Println("  usage                  统计用量与费用，支持CSV/JSON导出")
 }
 
 func handleList() {
@@ -74,9 +78,21 @@ func handleList() {
 		os.Exit(1)
 	}
 
+	// 熔断器状态由运行中的代理写出
+	breakerState, err := config.LoadBreakerState(config.BreakerStatePath(configFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: %v\n", err)
+	}
+
 	fmt.Println("\n当前API配置列表:")
 	fmt.Println("--------------------------------------------------------------------------------")
 	fmt.Printf("代理域名: %s\n", cfg.Domain)
+	if cfg.DefaultBackend != "" {
+		fmt.Printf("默认后端: %s\n", cfg.DefaultBackend)
+	}
+	if cfg.Strict {
+		fmt.Println("严格模式: 开启（未知模型返回404）")
+	}
 	fmt.Println("--------------------------------------------------------------------------------")
 
 	for i, api := range cfg.APIs {
@@ -93,8 +109,104 @@ func handleList() {
 		fmt.Printf("   自定义模型ID: %s\n", api.CustomModelID)
 		fmt.Printf("   目标模型ID: %s\n", api.TargetModelID)
 		fmt.Printf("   流模式: %s\n", streamMode)
+		if api.ClientStreamMode != "" {
+			fmt.Printf("   客户端流模式: %s\n", api.ClientStreamMode)
+		}
+		if api.Priority != 0 {
+			fmt.Printf("   优先级: %d\n", api.Priority)
+		}
+		if api.Weight != 0 {
+			fmt.Printf("   权重: %d\n", api.Weight)
+		}
+		if api.Compat != "" {
+			fmt.Printf("   兼容性配置: %s\n", api.Compat)
+		}
+		if api.ReasoningMode != "" {
+			fmt.Printf("   推理内容: %s\n", api.ReasoningMode)
+		}
+		if api.ToolEmulation {
+			fmt.Printf("   工具调用模拟: 开启\n")
+		}
+		for _, rule := range api.SystemPrompts {
+			fmt.Printf("   系统提示词(%s): %s\n", rule.Mode, rule.Content)
+		}
+		if api.Context != nil {
+			strategy := api.Context.Strategy
+			if strategy == "" {
+				strategy = "truncate"
+			}
+			fmt.Printf("   上下文窗口: %d tokens（%s）\n", api.Context.Window, strategy)
+		}
+		if p := api.Pricing; p != nil {
+			currency := p.Currency
+			if currency == "" {
+				currency = config.CurrencyCNY
+			}
+			cachedInput := p.CachedInput
+			if cachedInput == 0 {
+				cachedInput = p.Input
+			}
+			fmt.Printf("   价格(每百万token): 输入 %g 缓存输入 %g 输出 %g %s\n", p.Input, cachedInput, p.Output, currency)
+		}
+		if api.UpstreamProxy != "" {
+			fmt.Printf("   出站代理: %s\n", config.RedactUpstreamProxy(api.UpstreamProxy))
+		}
+		fmt.Printf("   API密钥: %s\n", config.MaskAPIKey(api.APIKey))
+		if breakerState != nil {
+			if st, ok := breakerState.Backends[api.Name]; ok {
+				fmt.Printf("   熔断状态: %s（%s起，连续失败%d次）\n", st.State, st.Since.Local().Format("2006-01-02 15:04:05"), st.ConsecutiveFailures)
+			}
+		}
 		fmt.Println("--------------------------------------------------------------------------------")
 	}
+	if breakerState != nil {
+		fmt.Printf("熔断状态更新于: %s\n", breakerState.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	if len(cfg.Routes) > 0 {
+		fmt.Println("\n路由规则:")
+		for i, route := range cfg.Routes {
+			var match []string
+			switch {
+			case route.Model != "":
+				match = append(match, "model="+route.Model)
+			case route.Glob != "":
+				match = append(match, "glob="+route.Glob)
+			case route.Regex != "":
+				match = append(match, "regex="+route.Regex)
+			case len(route.Aliases) > 0:
+				match = append(match, "aliases="+strings.Join(route.Aliases, ","))
+			}
+			if route.When != nil {
+				match = append(match, formatRouteCondition(route.When))
+			}
+			fmt.Printf("%d. %s -> %s\n", i+1, strings.Join(match, " "), route.Backend)
+		}
+	}
+}
+
+// formatRouteCondition 格式化路由条件用于显示
+func formatRouteCondition(when *models.RouteCondition) string {
+	var parts []string
+	if when.HasImages != nil {
+		parts = append(parts, fmt.Sprintf("has_images=%v", *when.HasImages))
+	}
+	if when.HasTools != nil {
+		parts = append(parts, fmt.Sprintf("has_tools=%v", *when.HasTools))
+	}
+	if when.Stream != nil {
+		parts = append(parts, fmt.Sprintf("stream=%v", *when.Stream))
+	}
+	if when.MinPromptTokens > 0 {
+		parts = append(parts, fmt.Sprintf("prompt_tokens>=%d", when.MinPromptTokens))
+	}
+	if when.MaxPromptTokens > 0 {
+		parts = append(parts, fmt.Sprintf("prompt_tokens<=%d", when.MaxPromptTokens))
+	}
+	for name, value := range when.Headers {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+	}
+	return "when(" + strings.Join(parts, ", ") + ")"
 }
 
 func handleAdd() {
@@ -103,7 +215,12 @@ func handleAdd() {
 	endpoint := fs.String("endpoint", "", "后端API URL（必需）")
 	customModel := fs.String("custom-model", "", "自定义模型ID（必需）")
 	targetModel := fs.String("target-model", "", "目标模型ID（必需）")
-	streamMode := fs.String("stream-mode", "none", "流模式 (true/false/none)")
+	streamMode := fs.String("stream-mode", "none", "流模式 (true/false/simulate/none)")
+	clientStreamMode := fs.String("client-stream-mode", "none", "返回给客户端的流模式 (true/false/none)")
+	apiKey := fs.String("api-key", "", "后端API密钥（明文、${ENV} 或 file:路径）")
+	priority := fs.Int("priority", 0, "优先级，共用自定义模型ID时数值小的优先")
+	weight := fs.Int("weight", 0, "加权负载均衡的权重（默认1）")
+	upstreamProxy := fs.String("upstream-proxy", "", "出站代理 (http://、https://、socks5:// 地址, direct, env)")
 	active := fs.Bool("active", false, "激活此API配置")
 
 	fs.Parse(os.Args[2:])
@@ -112,6 +229,12 @@ func handleAdd() {
 		fmt.Fprintf(os.Stderr, "错误: name, endpoint, custom-model, target-model 都是必需的\n")
 		os.Exit(1)
 	}
+	switch *clientStreamMode {
+	case "true", "false", "none":
+	default:
+		fmt.Fprintf(os.Stderr, "错误: 无效的客户端流模式: %s\n", *clientStreamMode)
+		os.Exit(1)
+	}
 
 	// 验证URL格式
 	if _, err := url.Parse(*endpoint); err != nil {
@@ -119,6 +242,15 @@ func handleAdd() {
 		os.Exit(1)
 	}
 
+	if err := config.ValidateAPIKey(*apiKey); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的API密钥: %v\n", err)
+		os.Exit(1)
+	}
+	if err := config.ValidateUpstreamProxy(*upstreamProxy); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的出站代理: %v\n", err)
+		os.Exit(1)
+	}
+
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
 		cfg = config.GetDefaultConfig()
@@ -130,13 +262,23 @@ func handleAdd() {
 		streamModeValue = *streamMode
 	}
 
+	clientStreamModeValue := ""
+	if *clientStreamMode != "none" {
+		clientStreamModeValue = *clientStreamMode
+	}
+
 	newAPI := models.API{
-		Name:          *name,
-		Endpoint:      *endpoint,
-		CustomModelID: *customModel,
-		TargetModelID: *targetModel,
-		StreamMode:    streamModeValue,
-		Active:        *active,
+		Name:             *name,
+		Endpoint:         *endpoint,
+		CustomModelID:    *customModel,
+		TargetModelID:    *targetModel,
+		StreamMode:       streamModeValue,
+		Active:           *active,
+		ClientStreamMode: clientStreamModeValue,
+		APIKey:           *apiKey,
+		Priority:         *priority,
+		Weight:           *weight,
+		UpstreamProxy:    *upstreamProxy,
 	}
 
 	if *active {
@@ -201,7 +343,12 @@ func handleUpdate() {
 	endpoint := fs.String("endpoint", "", "后端API URL")
 	customModel := fs.String("custom-model", "", "自定义模型ID")
 	targetModel := fs.String("target-model", "", "目标模型ID")
-	streamMode := fs.String("stream-mode", "", "流模式 (true/false/none)")
+	streamMode := fs.String("stream-mode", "", "流模式 (true/false/simulate/none)")
+	clientStreamMode := fs.String("client-stream-mode", "", "返回给客户端的流模式 (true/false/none)")
+	apiKey := fs.String("api-key", "", "后端API密钥（明文、${ENV}、file:路径，none 表示清除）")
+	priority := fs.Int("priority", 0, "优先级，共用自定义模型ID时数值小的优先")
+	weight := fs.Int("weight", 0, "加权负载均衡的权重（默认1）")
+	upstreamProxy := fs.String("upstream-proxy", "", "出站代理 (http://、https://、socks5:// 地址, direct, env)")
 	active := fs.Bool("active", false, "激活此API配置")
 	hasActive := fs.Bool("set-active", false, "设置激活状态（使用-set-active=true/false）")
 
@@ -211,6 +358,12 @@ func handleUpdate() {
 		fmt.Fprintf(os.Stderr, "错误: index 是必需的且必须 >= 0\n")
 		os.Exit(1)
 	}
+	switch *clientStreamMode {
+	case "", "true", "false", "none":
+	default:
+		fmt.Fprintf(os.Stderr, "错误: 无效的客户端流模式: %s\n", *clientStreamMode)
+		os.Exit(1)
+	}
 
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
@@ -248,6 +401,39 @@ func handleUpdate() {
 			api.StreamMode = *streamMode
 		}
 	}
+	if *clientStreamMode != "" {
+		if *clientStreamMode == "none" {
+			api.ClientStreamMode = ""
+		} else {
+			api.ClientStreamMode = *clientStreamMode
+		}
+	}
+	if *apiKey != "" {
+		if *apiKey == "none" {
+			api.APIKey = ""
+		} else {
+			if err := config.ValidateAPIKey(*apiKey); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: 无效的API密钥: %v\n", err)
+				os.Exit(1)
+			}
+			api.APIKey = *apiKey
+		}
+	}
+	if *upstreamProxy != "" {
+		if err := config.ValidateUpstreamProxy(*upstreamProxy); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的出站代理: %v\n", err)
+			os.Exit(1)
+		}
+		api.UpstreamProxy = *upstreamProxy
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "priority":
+			api.Priority = *priority
+		case "weight":
+			api.Weight = *weight
+		}
+	})
 	if *hasActive {
 		api.Active = *active
 		if *active {
@@ -469,6 +655,16 @@ func handleDoctor() {
 	}
 	fmt.Println()
 
+	fmt.Println("Backend proxies:")
+	for _, bp := range doctor.ResolveBackendProxies(cfg.APIs) {
+		if bp.Error != "" {
+			fmt.Printf("  %s (%s): error: %s [upstream_proxy=%s]\n", bp.Name, bp.Endpoint, bp.Error, bp.Setting)
+			continue
+		}
+		fmt.Printf("  %s (%s): %s [upstream_proxy=%s]\n", bp.Name, bp.Endpoint, bp.Proxy, bp.Setting)
+	}
+	fmt.Println()
+
 	fmt.Println("Suggested settings:")
 	fmt.Printf("  NO_PROXY=%s\n", report.SuggestedNoProxy)
 	fmt.Println("  PowerShell: $env:NO_PROXY=\"<value above>\"")
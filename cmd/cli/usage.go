@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+// usageTotal 一个分组的用量合计，不同币种分别合计
+type usageTotal struct {
+	Key              string  `json:"key"`
+	Currency         string  `json:"currency,omitempty"`
+	Requests         int     `json:"requests"`
+	FailedCount      int     `json:"failed_requests"`
+	EstimatedCount   int     `json:"estimated_requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	ReasoningTokens  int     `json:"reasoning_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+
+	heuristicCount int // 按字符数启发式估算的请求数
+}
+
+func (t *usageTotal) add(rec *models.UsageRecord) {
+	t.Requests++
+	if rec.Status != 0 {
+		t.FailedCount++
+	}
+	if rec.Estimated {
+		t.EstimatedCount++
+		if rec.EstimateMethod == "heuristic" {
+			t.heuristicCount++
+		}
+	}
+	t.PromptTokens += rec.PromptTokens
+	t.CachedTokens += rec.CachedTokens
+	t.CompletionTokens += rec.CompletionTokens
+	t.ReasoningTokens += rec.ReasoningTokens
+	t.TotalTokens += rec.TotalTokens
+	t.Cost += rec.Cost
+}
+
+// usageGroupKey 返回记录在指定维度下的分组键
+func usageGroupKey(rec *models.UsageRecord, by string) string {
+	switch by {
+	case "backend":
+		return rec.Backend
+	case "model":
+		return rec.Model
+	case "client":
+		return rec.Client
+	}
+	return rec.Time.Local().Format("2006-01-02")
+}
+
+// summarizeUsage 按维度和币种合计用量，另外返回各币种的总计
+func summarizeUsage(records []models.UsageRecord, by string) ([]*usageTotal, []*usageTotal) {
+	groups := map[[2]string]*usageTotal{}
+	overall := map[string]*usageTotal{}
+	for i := range records {
+		rec := &records[i]
+		key := usageGroupKey(rec, by)
+		group, ok := groups[[2]string{key, rec.Currency}]
+		if !ok {
+			group = &usageTotal{Key: key, Currency: rec.Currency}
+			groups[[2]string{key, rec.Currency}] = group
+		}
+		group.add(rec)
+
+		total, ok := overall[rec.Currency]
+		if !ok {
+			total = &usageTotal{Key: "合计", Currency: rec.Currency}
+			overall[rec.Currency] = total
+		}
+		total.add(rec)
+	}
+
+	var rows, totals []*usageTotal
+	for _, group := range groups {
+		rows = append(rows, group)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Key != rows[j].Key {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].Currency < rows[j].Currency
+	})
+	for _, total := range overall {
+		totals = append(totals, total)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	return rows, totals
+}
+
+// filterUsage 保留[from, to)时间范围内的记录，零值表示不限制
+func filterUsage(records []models.UsageRecord, from, to time.Time) []models.UsageRecord {
+	var out []models.UsageRecord
+	for _, rec := range records {
+		if !from.IsZero() && rec.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !rec.Time.Before(to) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// parseUsageRange 解析-from/-to（YYYY-MM-DD，包含当天）或-month（YYYY-MM），按本地时间计算
+func parseUsageRange(fromStr, toStr, monthStr string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	if monthStr != "" {
+		month, err := time.ParseInLocation("2006-01", monthStr, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("无效的月份: %s（格式 YYYY-MM）", monthStr)
+		}
+		return month, month.AddDate(0, 1, 0), nil
+	}
+	if fromStr != "" {
+		day, err := time.ParseInLocation("2006-01-02", fromStr, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("无效的开始日期: %s（格式 YYYY-MM-DD）", fromStr)
+		}
+		from = day
+	}
+	if toStr != "" {
+		day, err := time.ParseInLocation("2006-01-02", toStr, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("无效的结束日期: %s（格式 YYYY-MM-DD）", toStr)
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+func handleUsage() {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	configPath := fs.String("config", configFile, "配置文件路径")
+	ledgerPath := fs.String("ledger", "", "用量账本路径（默认为配置文件目录下的usage.jsonl）")
+	by := fs.String("by", "day", "合计维度: day, backend, model, client")
+	from := fs.String("from", "", "开始日期 YYYY-MM-DD（包含）")
+	to := fs.String("to", "", "结束日期 YYYY-MM-DD（包含）")
+	month := fs.String("month", "", "统计月份 YYYY-MM，设置后忽略-from和-to")
+	format := fs.String("format", "table", "输出格式: table, csv, json")
+	records := fs.Bool("records", false, "导出每个请求的明细而不是合计")
+	output := fs.String("o", "", "输出文件（默认输出到终端）")
+
+	fs.Parse(os.Args[2:])
+
+	switch *by {
+	case "day", "backend", "model", "client":
+	default:
+		fmt.Fprintf(os.Stderr, "无效的合计维度: %s\n", *by)
+		os.Exit(1)
+	}
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "无效的输出格式: %s\n", *format)
+		os.Exit(1)
+	}
+	start, end, err := parseUsageRange(*from, *to, *month)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	path := *ledgerPath
+	if path == "" {
+		path = config.UsageLedgerPath(*configPath)
+	}
+	all, err := config.LoadUsageRecords(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载用量账本失败: %v\n", err)
+		os.Exit(1)
+	}
+	selected := filterUsage(all, start, end)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "创建输出文件失败: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *records {
+		err = writeUsageRecords(w, selected, *format)
+	} else {
+		rows, totals := summarizeUsage(selected, *by)
+		err = writeUsageTotals(w, rows, totals, *by, *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "输出失败: %v\n", err)
+		os.Exit(1)
+	}
+	if *output != "" {
+		fmt.Printf("已导出 %d 条记录的用量到 %s\n", len(selected), *output)
+	}
+}
+
+// writeUsageTotals 输出合计，CSV只包含分组行，便于在表格软件中再次汇总
+func writeUsageTotals(w io.Writer, rows, totals []*usageTotal, by, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"group_by": by,
+			"rows":     rows,
+			"totals":   totals,
+		})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{by, "currency", "requests", "failed_requests", "estimated_requests", "prompt_tokens", "cached_tokens", "completion_tokens", "reasoning_tokens", "total_tokens", "cost"})
+		for _, row := range rows {
+			cw.Write([]string{
+				row.Key, row.Currency,
+				strconv.Itoa(row.Requests), strconv.Itoa(row.FailedCount), strconv.Itoa(row.EstimatedCount),
+				strconv.Itoa(row.PromptTokens), strconv.Itoa(row.CachedTokens),
+				strconv.Itoa(row.CompletionTokens), strconv.Itoa(row.ReasoningTokens),
+				strconv.Itoa(row.TotalTokens), strconv.FormatFloat(row.Cost, 'f', 6, 64),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "没有用量记录")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\t请求数\t失败\t输入\t缓存命中\t输出\t推理\t总计\t费用\n", usageDimensionName(by))
+	for _, row := range append(rows, totals...) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			row.Key, row.Requests, row.FailedCount, row.PromptTokens, row.CachedTokens,
+			row.CompletionTokens, row.ReasoningTokens, row.TotalTokens, formatCost(row.Cost, row.Currency))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	estimated, heuristic := 0, 0
+	for _, total := range totals {
+		estimated += total.EstimatedCount
+		heuristic += total.heuristicCount
+	}
+	if estimated > 0 {
+		fmt.Fprintf(w, "\n其中 %d 个请求的后端未返回用量，为本地估算", estimated)
+		if heuristic > 0 {
+			fmt.Fprintf(w, "（%d 个在未加载BPE词表时按字符数启发式估算，可能与后端计费有较大偏差）", heuristic)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// writeUsageRecords 输出每个请求的明细
+func writeUsageRecords(w io.Writer, records []models.UsageRecord, format string) error {
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "client", "backend", "model", "target_model", "path", "stream", "prompt_tokens", "cached_tokens", "completion_tokens", "reasoning_tokens", "total_tokens", "estimated", "estimate_method", "status", "cost", "currency"})
+		for _, rec := range records {
+			cw.Write([]string{
+				rec.Time.Local().Format(time.RFC3339), rec.Client, rec.Backend, rec.Model, rec.TargetModel, rec.Path,
+				strconv.FormatBool(rec.Stream),
+				strconv.Itoa(rec.PromptTokens), strconv.Itoa(rec.CachedTokens),
+				strconv.Itoa(rec.CompletionTokens), strconv.Itoa(rec.ReasoningTokens),
+				strconv.Itoa(rec.TotalTokens), strconv.FormatBool(rec.Estimated), rec.EstimateMethod,
+				strconv.Itoa(rec.Status), strconv.FormatFloat(rec.Cost, 'f', 6, 64), rec.Currency,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	// 明细没有适合终端的表格形式，table按JSON输出
+	if records == nil {
+		records = []models.UsageRecord{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func usageDimensionName(by string) string {
+	switch by {
+	case "backend":
+		return "后端"
+	case "model":
+		return "模型"
+	case "client":
+		return "客户端"
+	}
+	return "日期"
+}
+
+// formatCost 格式化费用，未配置价格的记录显示为-
+func formatCost(cost float64, currency string) string {
+	if currency == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f %s", cost, currency)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"trae-proxy-go/internal/config"
 	"trae-proxy-go/internal/logger"
 	"trae-proxy-go/internal/proxy"
@@ -62,9 +66,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	srv.SetBreakerStateFile(config.BreakerStatePath(*configPath))
+	if err := srv.SetUsageLedger(config.UsageLedgerPath(*configPath)); err != nil {
+		log.Error("用量账本不可用，将不记录用量: %v", err)
+	}
+
+	if err := srv.SetTokenizer(config.TokenizerPath(*configPath, cfg)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) && cfg.TokenizerFile == "" {
+			log.Info("未找到BPE词表 %s，本地token估算按字符数启发式计算", config.TokenizerPath(*configPath, cfg))
+		} else {
+			log.Error("BPE词表不可用，本地token估算按字符数启发式计算: %v", err)
+		}
+	}
+
+	// 收到退出信号时关闭服务器，保证用量账本写入磁盘
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := srv.Close(); err != nil {
+			log.Error("%v", err)
+		}
+		os.Exit(0)
+	}()
+
 	// 启动服务器
 	if err := srv.Start(); err != nil {
 		log.Error("服务器启动失败: %v", err)
+		srv.Close()
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const apiKeyFilePrefix = "file:"
+
+var envRefPattern = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// ResolveAPIKey 解析后端密钥配置，返回实际使用的密钥
+// 支持三种写法: 明文 "sk-xxx"、环境变量引用 "${DEEPSEEK_API_KEY}"、密钥文件 "file:/path/to/key"
+func ResolveAPIKey(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+
+	if m := envRefPattern.FindStringSubmatch(raw); m != nil {
+		value, ok := os.LookupEnv(m[1])
+		if !ok || strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("环境变量 %s 未设置", m[1])
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	if strings.HasPrefix(raw, apiKeyFilePrefix) {
+		path := strings.TrimSpace(strings.TrimPrefix(raw, apiKeyFilePrefix))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("读取密钥文件失败: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("密钥文件 %s 为空", path)
+		}
+		return key, nil
+	}
+
+	return raw, nil
+}
+
+// MaskAPIKey 返回用于展示的密钥描述，明文密钥只保留首尾少量字符
+func MaskAPIKey(raw string) string {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return "None"
+	case envRefPattern.MatchString(raw):
+		return raw
+	case strings.HasPrefix(raw, apiKeyFilePrefix):
+		return raw
+	case len(raw) <= 8:
+		return strings.Repeat("*", len(raw))
+	default:
+		return raw[:4] + strings.Repeat("*", 4) + raw[len(raw)-4:]
+	}
+}
+
+// ValidateAPIKey 校验密钥配置的写法，不在加载阶段读取环境变量或文件
+func ValidateAPIKey(raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	if strings.HasPrefix(raw, "${") && !envRefPattern.MatchString(raw) {
+		return fmt.Errorf("无效的环境变量引用: %s", raw)
+	}
+	if strings.HasPrefix(raw, apiKeyFilePrefix) && strings.TrimSpace(strings.TrimPrefix(raw, apiKeyFilePrefix)) == "" {
+		return fmt.Errorf("密钥文件路径不能为空")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"trae-proxy-go/pkg/models"
+)
+
+// breakerStateFileName 熔断器状态文件名，与配置文件位于同一目录
+const breakerStateFileName = "breaker_state.json"
+
+// BreakerStatePath 返回配置文件对应的熔断器状态文件路径
+func BreakerStatePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), breakerStateFileName)
+}
+
+// LoadBreakerState 读取熔断器状态文件，文件不存在时返回nil
+func LoadBreakerState(path string) (*models.BreakerStateFile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取熔断器状态失败: %w", err)
+	}
+
+	var state models.BreakerStateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析熔断器状态失败: %w", err)
+	}
+	return &state, nil
+}
+
+// SaveBreakerState 写入熔断器状态文件，先写临时文件再重命名，避免读到不完整的内容
+func SaveBreakerState(path string, state *models.BreakerStateFile) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化熔断器状态失败: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入熔断器状态失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入熔断器状态失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"trae-proxy-go/pkg/models"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+
+// commonCompatProfile 国内OpenAI兼容服务商普遍不支持的字段
+func commonCompatProfile() models.CompatProfile {
+	return models.CompatProfile{
+		Roles:  map[string]string{"developer": "system"},
+		Rename: map[string]string{"max_completion_tokens": "max_tokens"},
+		Drop:   []string{"store", "metadata", "service_tier", "reasoning_effort", "prediction", "modalities", "audio"},
+	}
+}
+
+// BuiltinCompatProfiles 内置的兼容性配置，自定义配置同名时覆盖内置配置
+var BuiltinCompatProfiles = map[string]models.CompatProfile{
+	"deepseek": func() models.CompatProfile {
+		p := commonCompatProfile()
+		p.Drop = append(p.Drop, "parallel_tool_calls", "n")
+		p.Clamp = map[string]models.ValueRange{
+			"temperature":       {Min: floatPtr(0), Max: floatPtr(2)},
+			"top_p":             {Min: floatPtr(0), Max: floatPtr(1)},
+			"presence_penalty":  {Min: floatPtr(-2), Max: floatPtr(2)},
+			"frequency_penalty": {Min: floatPtr(-2), Max: floatPtr(2)},
+		}
+		return p
+	}(),
+	"moonshot": func() models.CompatProfile {
+		p := commonCompatProfile()
+		p.Drop = append(p.Drop, "parallel_tool_calls", "logprobs", "top_logprobs", "logit_bias", "user")
+		p.Clamp = map[string]models.ValueRange{
+			"temperature":       {Min: floatPtr(0), Max: floatPtr(1)},
+			"top_p":             {Min: floatPtr(0), Max: floatPtr(1)},
+			"n":                 {Min: floatPtr(1), Max: floatPtr(5)},
+			"presence_penalty":  {Min: floatPtr(-2), Max: floatPtr(2)},
+			"frequency_penalty": {Min: floatPtr(-2), Max: floatPtr(2)},
+		}
+		return p
+	}(),
+	"dashscope": func() models.CompatProfile {
+		p := commonCompatProfile()
+		p.Drop = append(p.Drop, "logit_bias", "user")
+		p.Clamp = map[string]models.ValueRange{
+			"temperature":      {Min: floatPtr(0), Max: floatPtr(1.99)},
+			"top_p":            {Min: floatPtr(0.01), Max: floatPtr(1)},
+			"presence_penalty": {Min: floatPtr(-2), Max: floatPtr(2)},
+		}
+		return p
+	}(),
+}
+
+// LookupCompatProfile 按名称查找兼容性配置，自定义配置优先
+func LookupCompatProfile(config *models.Config, name string) (*models.CompatProfile, bool) {
+	if profile, ok := config.CompatProfiles[name]; ok {
+		return &profile, true
+	}
+	if profile, ok := BuiltinCompatProfiles[name]; ok {
+		return &profile, true
+	}
+	return nil, false
+}
+
+// validateCompatProfiles 验证自定义兼容性配置以及后端引用的配置名
+func validateCompatProfiles(config *models.Config) error {
+	for name, profile := range config.CompatProfiles {
+		for field, r := range profile.Clamp {
+			if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+				return fmt.Errorf("兼容性配置 %s 中 %s 的min不能大于max", name, field)
+			}
+		}
+	}
+	for i, api := range config.APIs {
+		if api.Compat == "" {
+			continue
+		}
+		if _, ok := LookupCompatProfile(config, api.Compat); !ok {
+			return fmt.Errorf("API配置[%d]的兼容性配置不存在: %s", i, api.Compat)
+		}
+	}
+	return nil
+}
@@ -65,6 +65,87 @@ func validateConfig(config *models.Config) error {
 		if api.TargetModelID == "" {
 			return fmt.Errorf("API配置[%d]的target_model_id不能为空", i)
 		}
+		if !isValidStreamMode(api.StreamMode) && api.StreamMode != "simulate" {
+			return fmt.Errorf("API配置[%d]的stream_mode无效: %s", i, api.StreamMode)
+		}
+		if !isValidStreamMode(api.ClientStreamMode) {
+			return fmt.Errorf("API配置[%d]的client_stream_mode无效: %s", i, api.ClientStreamMode)
+		}
+		if api.Weight < 0 {
+			return fmt.Errorf("API配置[%d]的weight不能为负数", i)
+		}
+		if err := validateCircuitBreaker(api.CircuitBreaker); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := validateRetryPolicy(api.Retry); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := ValidateUpstreamProxy(api.UpstreamProxy); err != nil {
+			return fmt.Errorf("API配置[%d]的upstream_proxy无效: %w", i, err)
+		}
+		if err := validateParamPreset(api.Params); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := validateTransport(api.Transport); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := validateSystemPrompts(api.SystemPrompts); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := validateContext(config, api.Context); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		if err := validatePricing(api.Pricing); err != nil {
+			return fmt.Errorf("API配置[%d]的%w", i, err)
+		}
+		switch api.ReasoningMode {
+		case "", "passthrough", "drop", "fold", "extract":
+		default:
+			return fmt.Errorf("API配置[%d]的reasoning_mode无效: %s", i, api.ReasoningMode)
+		}
+		if api.Simulate != nil && (api.Simulate.ChunkSize < 0 || api.Simulate.Interval < 0) {
+			return fmt.Errorf("API配置[%d]的simulate配置不能为负数", i)
+		}
+		if err := ValidateAPIKey(api.APIKey); err != nil {
+			return fmt.Errorf("API配置[%d]的api_key无效: %w", i, err)
+		}
+	}
+
+	if err := validateCircuitBreaker(config.CircuitBreaker); err != nil {
+		return err
+	}
+	if err := validateRetryPolicy(config.Retry); err != nil {
+		return err
+	}
+	if err := validateRoutes(config); err != nil {
+		return err
+	}
+	if err := validateCompatProfiles(config); err != nil {
+		return err
+	}
+
+	for modelID, strategy := range config.LoadBalancing {
+		switch strategy {
+		case "round_robin", "weighted_random", "least_in_flight":
+		default:
+			return fmt.Errorf("模型 %s 的负载均衡策略无效: %s", modelID, strategy)
+		}
+		found := false
+		for _, api := range config.APIs {
+			if api.CustomModelID == modelID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("load_balancing中的模型 %s 未匹配任何custom_model_id", modelID)
+		}
+	}
+
+	for _, code := range config.Failover.StatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("failover.status_codes中的状态码无效: %d", code)
+		}
 	}
 
 	if config.Server.Port <= 0 || config.Server.Port > 65535 {
@@ -74,6 +155,15 @@ func validateConfig(config *models.Config) error {
 	return nil
 }
 
+// isValidStreamMode 检查流模式取值，空值表示跟随客户端请求
+func isValidStreamMode(mode string) bool {
+	switch mode {
+	case "", "true", "false":
+		return true
+	}
+	return false
+}
+
 // GetDefaultConfig 获取默认配置
 func GetDefaultConfig() *models.Config {
 	return &models.Config{
@@ -94,3 +184,122 @@ func GetDefaultConfig() *models.Config {
 		},
 	}
 }
+
+// validateCircuitBreaker 验证熔断器配置
+func validateCircuitBreaker(cb *models.CircuitBreaker) error {
+	if cb == nil {
+		return nil
+	}
+	if cb.ConsecutiveFailures < 0 || cb.Window < 0 || cb.MinRequests < 0 || cb.OpenDuration < 0 {
+		return fmt.Errorf("circuit_breaker配置不能为负数")
+	}
+	if cb.ErrorRate < 0 || cb.ErrorRate > 1 {
+		return fmt.Errorf("circuit_breaker.error_rate必须在0到1之间")
+	}
+	return nil
+}
+
+// validateRetryPolicy 验证重试策略
+func validateRetryPolicy(policy *models.RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxAttempts < 0 || policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+		return fmt.Errorf("retry配置不能为负数")
+	}
+	if policy.Jitter != nil && (*policy.Jitter < 0 || *policy.Jitter > 1) {
+		return fmt.Errorf("retry.jitter必须在0到1之间")
+	}
+	for _, code := range policy.StatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("retry.status_codes中的状态码无效: %d", code)
+		}
+	}
+	return nil
+}
+
+// validateTransport 验证后端连接配置
+func validateTransport(t *models.TransportConfig) error {
+	if t == nil {
+		return nil
+	}
+	if t.ConnectTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.FirstByteTimeout < 0 ||
+		t.IdleStreamTimeout < 0 || t.TotalTimeout < 0 || t.IdleConnTimeout < 0 {
+		return fmt.Errorf("transport的超时不能为负数")
+	}
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 {
+		return fmt.Errorf("transport的连接数不能为负数")
+	}
+	if t.TotalTimeout > 0 && t.FirstByteTimeout > t.TotalTimeout {
+		return fmt.Errorf("transport.first_byte_timeout不能大于total_timeout")
+	}
+	return nil
+}
+
+// validateSystemPrompts 验证系统提示词规则
+func validateSystemPrompts(rules []models.SystemPromptRule) error {
+	for i, rule := range rules {
+		switch rule.Mode {
+		case "prepend", "append", "replace":
+		default:
+			return fmt.Errorf("system_prompts[%d]的mode无效: %s", i, rule.Mode)
+		}
+		if rule.Content == "" {
+			return fmt.Errorf("system_prompts[%d]的content不能为空", i)
+		}
+	}
+	return nil
+}
+
+// validateContext 验证上下文窗口配置
+func validateContext(config *models.Config, c *models.ContextConfig) error {
+	if c == nil {
+		return nil
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("context.window必须大于0")
+	}
+	if c.ReserveOutput < 0 || c.ReserveOutput >= c.Window {
+		return fmt.Errorf("context.reserve_output必须在0到window之间")
+	}
+	if c.KeepTurns < 0 {
+		return fmt.Errorf("context.keep_turns不能为负数")
+	}
+	switch c.Strategy {
+	case "", "truncate":
+	case "summarize":
+		if c.SummaryBackend == "" {
+			return fmt.Errorf("context.strategy为summarize时必须设置summary_backend")
+		}
+		if !hasBackend(config, c.SummaryBackend) {
+			return fmt.Errorf("context.summary_backend不存在: %s", c.SummaryBackend)
+		}
+	default:
+		return fmt.Errorf("context.strategy无效: %s", c.Strategy)
+	}
+	return nil
+}
+
+// presetReservedFields 由代理管理、不能通过params调整的字段
+var presetReservedFields = []string{"model", "messages", "stream"}
+
+// validateParamPreset 验证参数预设
+func validateParamPreset(preset *models.ParamPreset) error {
+	if preset == nil {
+		return nil
+	}
+	for _, field := range presetReservedFields {
+		_, inDefaults := preset.Defaults[field]
+		_, inOverrides := preset.Overrides[field]
+		removed := false
+		for _, key := range preset.Remove {
+			if key == field {
+				removed = true
+			}
+		}
+		if inDefaults || inOverrides || removed {
+			return fmt.Errorf("params不能修改%s字段", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"trae-proxy-go/pkg/models"
+)
+
+// RoutePattern 返回glob或regex规则编译后的正则表达式，精确匹配和别名规则返回nil
+// regex与glob一样匹配完整的模型名，需要部分匹配时在表达式中加上 .*
+func RoutePattern(route models.Route) (*regexp.Regexp, error) {
+	switch {
+	case route.Glob != "":
+		return regexp.Compile(globToRegexp(route.Glob))
+	case route.Regex != "":
+		return regexp.Compile("^(?:" + route.Regex + ")$")
+	}
+	return nil, nil
+}
+
+// globToRegexp 将通配符转换为完整匹配的正则表达式，模型名中的 / 也可以被 * 匹配
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// validateRoutes 验证路由规则和默认后端
+func validateRoutes(config *models.Config) error {
+	if config.DefaultBackend != "" && !hasBackend(config, config.DefaultBackend) {
+		return fmt.Errorf("default_backend指定的后端不存在: %s", config.DefaultBackend)
+	}
+
+	for i, route := range config.Routes {
+		kinds := 0
+		for _, set := range []bool{route.Model != "", route.Glob != "", route.Regex != "", len(route.Aliases) > 0} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds > 1 || (kinds == 0 && route.When == nil) {
+			return fmt.Errorf("路由规则[%d]必须设置when或model、glob、regex、aliases中的一项（后四者只能选一）", i)
+		}
+		if when := route.When; when != nil {
+			if when.MinPromptTokens < 0 || when.MaxPromptTokens < 0 {
+				return fmt.Errorf("路由规则[%d]的token数不能为负数", i)
+			}
+			if when.MaxPromptTokens > 0 && when.MinPromptTokens > when.MaxPromptTokens {
+				return fmt.Errorf("路由规则[%d]的min_prompt_tokens不能大于max_prompt_tokens", i)
+			}
+		}
+		if _, err := RoutePattern(route); err != nil {
+			return fmt.Errorf("路由规则[%d]的匹配表达式无效: %w", i, err)
+		}
+		if !hasBackend(config, route.Backend) {
+			return fmt.Errorf("路由规则[%d]的目标后端不存在: %s", i, route.Backend)
+		}
+	}
+	return nil
+}
+
+// hasBackend 判断是否存在指定名称的后端
+func hasBackend(config *models.Config, name string) bool {
+	for _, api := range config.APIs {
+		if api.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"path/filepath"
+	"trae-proxy-go/pkg/models"
+)
+
+// tokenizerFileName 默认的BPE词表文件名，与配置文件位于同一目录
+const tokenizerFileName = "cl100k_base.tiktoken"
+
+// TokenizerPath 返回本地token估算使用的词表文件路径，tokenizer_file为相对路径时相对于配置文件所在目录
+func TokenizerPath(configPath string, cfg *models.Config) string {
+	dir := filepath.Dir(configPath)
+	if cfg.TokenizerFile == "" {
+		return filepath.Join(dir, tokenizerFileName)
+	}
+	if filepath.IsAbs(cfg.TokenizerFile) {
+		return cfg.TokenizerFile
+	}
+	return filepath.Join(dir, cfg.TokenizerFile)
+}
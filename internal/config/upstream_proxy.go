@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// upstream_proxy 的特殊取值
+const (
+	UpstreamProxyEnv    = "env"    // 使用 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量（默认）
+	UpstreamProxyDirect = "direct" // 不使用代理直连
+)
+
+// UpstreamProxyFunc 返回upstream_proxy对应的http.Transport.Proxy函数
+// 支持 http://、https://、socks5://、socks5h:// 代理地址，以及 direct 和 env（留空等同env）
+func UpstreamProxyFunc(raw string) (func(*http.Request) (*url.URL, error), error) {
+	switch strings.TrimSpace(raw) {
+	case "", UpstreamProxyEnv:
+		return http.ProxyFromEnvironment, nil
+	case UpstreamProxyDirect:
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("无效的代理地址: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("不支持的代理协议: %q（支持 http、https、socks5、direct、env）", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("代理地址缺少主机: %s", proxyURL.Redacted())
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// ValidateUpstreamProxy 校验upstream_proxy配置
+func ValidateUpstreamProxy(raw string) error {
+	_, err := UpstreamProxyFunc(raw)
+	return err
+}
+
+// RedactUpstreamProxy 隐藏upstream_proxy中的密码，用于显示
+func RedactUpstreamProxy(raw string) string {
+	proxyURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || proxyURL.Host == "" {
+		return raw
+	}
+	return proxyURL.Redacted()
+}
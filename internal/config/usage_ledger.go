@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+// usageLedgerFileName 用量账本文件名，与配置文件位于同一目录，每行一条JSON格式的用量记录
+const usageLedgerFileName = "usage.jsonl"
+
+// 账本写入参数
+const (
+	usageLedgerQueueSize    = 1024            // 等待写入的记录数上限
+	usageLedgerSyncInterval = 5 * time.Second // 定期同步到磁盘的间隔
+)
+
+// 价格币种
+const (
+	CurrencyCNY = "CNY"
+	CurrencyUSD = "USD"
+)
+
+// UsageLedgerPath 返回配置文件对应的用量账本路径
+func UsageLedgerPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), usageLedgerFileName)
+}
+
+// UsageLedger 用量账本写入器
+// 账本文件保持打开，记录经缓冲队列由后台goroutine按顺序追加，请求不等待磁盘写入；
+// 队列写空时把缓冲写入文件，每隔usageLedgerSyncInterval和关闭时同步到磁盘
+type UsageLedger struct {
+	file    *os.File
+	records chan models.UsageRecord
+	done    chan struct{}
+	onError func(error) // 后台写入失败时的回调，可以为nil
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// OpenUsageLedger 打开（或创建）账本文件并启动后台写入
+func OpenUsageLedger(path string, onError func(error)) (*UsageLedger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开用量账本失败: %w", err)
+	}
+	l := &UsageLedger{
+		file:    f,
+		records: make(chan models.UsageRecord, usageLedgerQueueSize),
+		done:    make(chan struct{}),
+		onError: onError,
+	}
+	go l.run()
+	return l, nil
+}
+
+// Append 将记录放入写入队列后立即返回，队列已满或账本已关闭时返回错误
+func (l *UsageLedger) Append(rec *models.UsageRecord) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return errors.New("用量账本已关闭")
+	}
+	select {
+	case l.records <- *rec:
+		return nil
+	default:
+		return errors.New("用量账本写入队列已满，丢弃一条记录")
+	}
+}
+
+// Close 写完队列中的记录，同步到磁盘后关闭文件
+func (l *UsageLedger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.records)
+	l.mu.Unlock()
+
+	<-l.done
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("关闭用量账本失败: %w", err)
+	}
+	return nil
+}
+
+// run 后台写入循环
+func (l *UsageLedger) run() {
+	defer close(l.done)
+	w := bufio.NewWriter(l.file)
+	ticker := time.NewTicker(usageLedgerSyncInterval)
+	defer ticker.Stop()
+
+	dirty := false
+	for {
+		select {
+		case rec, ok := <-l.records:
+			if !ok {
+				if dirty {
+					l.sync(w)
+				}
+				return
+			}
+			data, err := json.Marshal(&rec)
+			if err != nil {
+				l.report(fmt.Errorf("序列化用量记录失败: %w", err))
+				continue
+			}
+			w.Write(append(data, '\n'))
+			dirty = true
+			// 队列暂时为空时写入文件，进程崩溃时只会丢失尚未同步到磁盘的部分
+			if len(l.records) == 0 {
+				if err := w.Flush(); err != nil {
+					l.report(fmt.Errorf("写入用量账本失败: %w", err))
+				}
+			}
+		case <-ticker.C:
+			if dirty {
+				l.sync(w)
+				dirty = false
+			}
+		}
+	}
+}
+
+// sync 写入缓冲并同步到磁盘
+func (l *UsageLedger) sync(w *bufio.Writer) {
+	if err := w.Flush(); err != nil {
+		l.report(fmt.Errorf("写入用量账本失败: %w", err))
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		l.report(fmt.Errorf("同步用量账本失败: %w", err))
+	}
+}
+
+func (l *UsageLedger) report(err error) {
+	if l.onError != nil {
+		l.onError(err)
+	}
+}
+
+// LoadUsageRecords 读取账本中的所有用量记录，文件不存在时返回nil
+// 进程异常退出可能留下不完整的最后一行，无法解析的行会被跳过
+func LoadUsageRecords(path string) ([]models.UsageRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取用量账本失败: %w", err)
+	}
+	defer f.Close()
+
+	var records []models.UsageRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec models.UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取用量账本失败: %w", err)
+	}
+	return records, nil
+}
+
+// UsageCost 按后端价格计算用量记录的费用和币种，未配置价格时返回0和空币种
+func UsageCost(pricing *models.Pricing, rec *models.UsageRecord) (float64, string) {
+	if pricing == nil {
+		return 0, ""
+	}
+	currency := pricing.Currency
+	if currency == "" {
+		currency = CurrencyCNY
+	}
+	cachedPrice := pricing.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = pricing.Input
+	}
+	cached := min(rec.CachedTokens, rec.PromptTokens)
+	cost := float64(rec.PromptTokens-cached)*pricing.Input +
+		float64(cached)*cachedPrice +
+		float64(rec.CompletionTokens)*pricing.Output
+	return cost / 1e6, currency
+}
+
+// validatePricing 验证后端价格
+func validatePricing(pricing *models.Pricing) error {
+	if pricing == nil {
+		return nil
+	}
+	switch pricing.Currency {
+	case "", CurrencyCNY, CurrencyUSD:
+	default:
+		return fmt.Errorf("pricing.currency无效: %s（可选 CNY、USD）", pricing.Currency)
+	}
+	if pricing.Input < 0 || pricing.Output < 0 || pricing.CachedInput < 0 {
+		return fmt.Errorf("pricing价格不能为负数")
+	}
+	return nil
+}
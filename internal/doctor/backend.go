@@ -0,0 +1,54 @@
+package doctor
+
+import (
+	"net/http"
+	"strings"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+type BackendProxy struct {
+	Name     string
+	Endpoint string
+	Setting  string
+	Proxy    string
+	Error    string
+}
+
+func ResolveBackendProxies(apis []models.API) []BackendProxy {
+	out := make([]BackendProxy, 0, len(apis))
+	for _, api := range apis {
+		bp := BackendProxy{
+			Name:     api.Name,
+			Endpoint: api.Endpoint,
+			Setting:  config.RedactUpstreamProxy(strings.TrimSpace(api.UpstreamProxy)),
+			Proxy:    "direct",
+		}
+		if bp.Setting == "" {
+			bp.Setting = config.UpstreamProxyEnv
+		}
+
+		proxyFunc, err := config.UpstreamProxyFunc(api.UpstreamProxy)
+		if err != nil {
+			bp.Error = err.Error()
+			out = append(out, bp)
+			continue
+		}
+		if proxyFunc != nil {
+			req, err := http.NewRequest(http.MethodPost, strings.TrimRight(api.Endpoint, "/")+"/v1/chat/completions", nil)
+			if err != nil {
+				bp.Error = err.Error()
+				out = append(out, bp)
+				continue
+			}
+			proxyURL, err := proxyFunc(req)
+			if err != nil {
+				bp.Error = err.Error()
+			} else if proxyURL != nil {
+				bp.Proxy = proxyURL.Redacted()
+			}
+		}
+		out = append(out, bp)
+	}
+	return out
+}
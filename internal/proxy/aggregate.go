@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// chatAggregator 将chat.completion.chunk流合并为一个chat.completion对象
+type chatAggregator struct {
+	id                string
+	created           interface{}
+	model             interface{}
+	systemFingerprint interface{}
+	choices           map[int]*aggregatedChoice
+	usage             interface{}
+}
+
+// aggregatedChoice 单个choice的合并结果
+type aggregatedChoice struct {
+	role         string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    map[int]*aggregatedToolCall
+	finishReason interface{}
+}
+
+// aggregatedToolCall 单个tool_call的合并结果
+type aggregatedToolCall struct {
+	id        string
+	callType  string
+	name      string
+	arguments strings.Builder
+}
+
+func newChatAggregator() *chatAggregator {
+	return &chatAggregator{choices: map[int]*aggregatedChoice{}}
+}
+
+// add 合并一个chunk
+func (a *chatAggregator) add(chunk map[string]interface{}) {
+	if a.id == "" {
+		a.id, _ = chunk["id"].(string)
+	}
+	if a.created == nil {
+		a.created = chunk["created"]
+	}
+	if a.model == nil {
+		a.model = chunk["model"]
+	}
+	if a.systemFingerprint == nil {
+		a.systemFingerprint = chunk["system_fingerprint"]
+	}
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		a.usage = usage
+	}
+
+	choices, _ := chunk["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := intValue(choice["index"])
+		agg, ok := a.choices[index]
+		if !ok {
+			agg = &aggregatedChoice{toolCalls: map[int]*aggregatedToolCall{}}
+			a.choices[index] = agg
+		}
+
+		if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+			agg.finishReason = reason
+		}
+
+		delta, _ := choice["delta"].(map[string]interface{})
+		if role, ok := delta["role"].(string); ok && role != "" {
+			agg.role = role
+		}
+		if content, ok := delta["content"].(string); ok {
+			agg.content.WriteString(content)
+		}
+		if reasoning, ok := delta["reasoning_content"].(string); ok {
+			agg.reasoning.WriteString(reasoning)
+		}
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, rawCall := range toolCalls {
+			call, ok := rawCall.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			callIndex := intValue(call["index"])
+			tc, ok := agg.toolCalls[callIndex]
+			if !ok {
+				tc = &aggregatedToolCall{callType: "function"}
+				agg.toolCalls[callIndex] = tc
+			}
+			if id, ok := call["id"].(string); ok && id != "" {
+				tc.id = id
+			}
+			if callType, ok := call["type"].(string); ok && callType != "" {
+				tc.callType = callType
+			}
+			function, _ := call["function"].(map[string]interface{})
+			if name, ok := function["name"].(string); ok && name != "" {
+				tc.name = name
+			}
+			if args, ok := function["arguments"].(string); ok {
+				tc.arguments.WriteString(args)
+			}
+		}
+	}
+}
+
+// result 生成合并后的chat.completion对象
+func (a *chatAggregator) result() map[string]interface{} {
+	indexes := make([]int, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	choices := []interface{}{}
+	for _, index := range indexes {
+		agg := a.choices[index]
+		role := agg.role
+		if role == "" {
+			role = "assistant"
+		}
+		message := map[string]interface{}{"role": role}
+		if agg.content.Len() > 0 || len(agg.toolCalls) == 0 {
+			message["content"] = agg.content.String()
+		} else {
+			message["content"] = nil
+		}
+		if agg.reasoning.Len() > 0 {
+			message["reasoning_content"] = agg.reasoning.String()
+		}
+		if len(agg.toolCalls) > 0 {
+			callIndexes := make([]int, 0, len(agg.toolCalls))
+			for callIndex := range agg.toolCalls {
+				callIndexes = append(callIndexes, callIndex)
+			}
+			sort.Ints(callIndexes)
+			var toolCalls []interface{}
+			for _, callIndex := range callIndexes {
+				tc := agg.toolCalls[callIndex]
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"id":   tc.id,
+					"type": tc.callType,
+					"function": map[string]interface{}{
+						"name":      tc.name,
+						"arguments": tc.arguments.String(),
+					},
+				})
+			}
+			message["tool_calls"] = toolCalls
+		}
+		choices = append(choices, map[string]interface{}{
+			"index":         index,
+			"message":       message,
+			"finish_reason": agg.finishReason,
+		})
+	}
+
+	result := map[string]interface{}{
+		"id":      a.id,
+		"object":  "chat.completion",
+		"created": a.created,
+		"model":   a.model,
+		"choices": choices,
+	}
+	if a.systemFingerprint != nil {
+		result["system_fingerprint"] = a.systemFingerprint
+	}
+	if a.usage != nil {
+		result["usage"] = a.usage
+	}
+	return result
+}
+
+// aggregateChatStream 读取整个chunk流并合并为chat.completion对象
+func aggregateChatStream(r io.Reader) (map[string]interface{}, error) {
+	agg := newChatAggregator()
+	if err := readChatChunks(r, func(chunk map[string]interface{}) error {
+		agg.add(chunk)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(agg.choices) == 0 && agg.id == "" {
+		return nil, fmt.Errorf("后端流式响应为空")
+	}
+	return agg.result(), nil
+}
+
+// readJSON 读取后端响应为chat.completion对象，流式响应会被合并
+// 返回前按后端的reasoning_mode处理推理内容
+func (u *upstreamResponse) readJSON() (map[string]interface{}, error) {
+	var responseJSON map[string]interface{}
+	if u.stream {
+		var err error
+		if responseJSON, err = aggregateChatStream(u.resp.Body); err != nil {
+			return nil, err
+		}
+	} else if err := json.NewDecoder(u.resp.Body).Decode(&responseJSON); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	u.usage.observeResponse(responseJSON)
+	transformReasoningResponse(u.backend.ReasoningMode, responseJSON)
+	if u.toolEmulation {
+		extractToolCallsResponse(responseJSON)
+	}
+	return responseJSON, nil
+}
+
+// eachChunk 以chunk序列的形式读取后端响应，非流式响应会按模拟流式配置拆分为chunk
+func (u *upstreamResponse) eachChunk(fn func(chunk map[string]interface{}) error) error {
+	if u.stream {
+		reasoning := newReasoningStream(u.backend.ReasoningMode)
+		var toolCalls *toolCallStream
+		if u.toolEmulation {
+			toolCalls = newToolCallStream()
+		}
+		return readChatChunks(u.resp.Body, func(chunk map[string]interface{}) error {
+			u.usage.observeChunk(chunk)
+			if reasoning != nil {
+				reasoning.transform(chunk)
+			}
+			if toolCalls != nil {
+				toolCalls.transform(chunk)
+			}
+			// 改写后没有任何内容的chunk（例如整段被暂缓输出）不再返回
+			if (reasoning != nil || toolCalls != nil) && emptyChunk(chunk) {
+				return nil
+			}
+			return fn(chunk)
+		})
+	}
+	responseJSON, err := u.readJSON()
+	if err != nil {
+		return err
+	}
+	return simulateChunks(u.ctx, responseJSON, u.backend.Simulate, fn)
+}
+
+// emptyChunk 判断chunk是否不包含任何delta内容、结束原因和usage
+func emptyChunk(chunk map[string]interface{}) bool {
+	if chunk["usage"] != nil {
+		return false
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		return false
+	}
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if choice["finish_reason"] != nil {
+			return false
+		}
+		if delta, _ := choice["delta"].(map[string]interface{}); len(delta) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rewritesChunks 流式响应是否需要逐个chunk改写后再返回（推理内容处理、工具调用解析或删除注入的usage）
+func (u *upstreamResponse) rewritesChunks() bool {
+	return needsReasoningTransform(u.backend.ReasoningMode) || u.toolEmulation || u.usage.stripUsage()
+}
+
+// clientStream 返回给客户端的响应是否为流式
+// client_stream_mode 为空时跟随客户端请求中的stream
+func (u *upstreamResponse) clientStream(requested bool) bool {
+	switch u.backend.ClientStreamMode {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return requested
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sseStream 把chunk列表编码为后端的SSE响应体
+func sseStream(t *testing.T, chunks ...map[string]interface{}) string {
+	t.Helper()
+	var b strings.Builder
+	for _, chunk := range chunks {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString("data: " + string(data) + "\n\n")
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return b.String()
+}
+
+// withMeta 为chunk加上id、created、model
+func withMeta(chunk map[string]interface{}) map[string]interface{} {
+	chunk["id"] = "chatcmpl-1"
+	chunk["created"] = 1700000000
+	chunk["model"] = "upstream"
+	return chunk
+}
+
+func TestAggregateChatStream(t *testing.T) {
+	usage := map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+	withUsage := contentChunk("", "tool_calls")
+	withUsage["usage"] = usage
+
+	tests := []struct {
+		name   string
+		chunks []map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name: "正文和推理",
+			chunks: []map[string]interface{}{
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"role": "assistant", "reasoning_content": "先"}}}},
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"reasoning_content": "想"}}}},
+				contentChunk("你", nil),
+				contentChunk("好", nil),
+				contentChunk("", "stop"),
+			},
+			want: map[string]interface{}{
+				"role":              "assistant",
+				"content":           "你好",
+				"reasoning_content": "先想",
+				"finish_reason":     "stop",
+			},
+		},
+		{
+			name: "工具调用跨chunk拼接并附带usage",
+			chunks: []map[string]interface{}{
+				contentChunk("", nil),
+				toolCallChunk(0, "call_1", "read", `{"pa`),
+				toolCallChunk(1, "call_2", "list", ""),
+				toolCallChunk(0, "", "", `th":"a.go"}`),
+				toolCallChunk(1, "", "", `{}`),
+				withUsage,
+			},
+			want: map[string]interface{}{
+				"role":    "assistant",
+				"content": nil,
+				"tool_calls": []interface{}{
+					map[string]interface{}{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "read", "arguments": `{"path":"a.go"}`}},
+					map[string]interface{}{"id": "call_2", "type": "function", "function": map[string]interface{}{"name": "list", "arguments": `{}`}},
+				},
+				"finish_reason": "tool_calls",
+				"usage":         usage,
+			},
+		},
+		{
+			name: "被截断",
+			chunks: []map[string]interface{}{
+				contentChunk("一半", nil),
+				contentChunk("", "length"),
+			},
+			want: map[string]interface{}{
+				"role":          "assistant",
+				"content":       "一半",
+				"finish_reason": "length",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, chunk := range tt.chunks {
+				withMeta(chunk)
+			}
+			result, err := aggregateChatStream(strings.NewReader(sseStream(t, tt.chunks...)))
+			if err != nil {
+				t.Fatalf("aggregateChatStream: %v", err)
+			}
+			// 经过JSON往返，便于与期望值比较
+			data, _ := json.Marshal(result)
+			var got map[string]interface{}
+			json.Unmarshal(data, &got)
+			wantJSON, _ := json.Marshal(tt.want)
+			var want map[string]interface{}
+			json.Unmarshal(wantJSON, &want)
+
+			if got["id"] != "chatcmpl-1" || got["object"] != "chat.completion" || got["model"] != "upstream" {
+				t.Errorf("元数据 = %v %v %v", got["id"], got["object"], got["model"])
+			}
+			choices := got["choices"].([]interface{})
+			if len(choices) != 1 {
+				t.Fatalf("choices = %v", choices)
+			}
+			choice := choices[0].(map[string]interface{})
+			message := choice["message"].(map[string]interface{})
+			for _, key := range []string{"role", "content", "reasoning_content", "tool_calls"} {
+				if !reflect.DeepEqual(message[key], want[key]) {
+					t.Errorf("message.%s = %v, want %v", key, message[key], want[key])
+				}
+			}
+			if choice["finish_reason"] != want["finish_reason"] {
+				t.Errorf("finish_reason = %v, want %v", choice["finish_reason"], want["finish_reason"])
+			}
+			if !reflect.DeepEqual(got["usage"], want["usage"]) {
+				t.Errorf("usage = %v, want %v", got["usage"], want["usage"])
+			}
+		})
+	}
+}
+
+func TestAggregateChatStreamMultipleChoices(t *testing.T) {
+	chunk := func(index int, content string) map[string]interface{} {
+		return withMeta(map[string]interface{}{"choices": []interface{}{
+			map[string]interface{}{"index": index, "delta": map[string]interface{}{"content": content}},
+		}})
+	}
+	result, err := aggregateChatStream(strings.NewReader(sseStream(t, chunk(1, "b"), chunk(0, "a"), chunk(1, "c"))))
+	if err != nil {
+		t.Fatalf("aggregateChatStream: %v", err)
+	}
+	choices := result["choices"].([]interface{})
+	var got []string
+	for _, raw := range choices {
+		choice := raw.(map[string]interface{})
+		got = append(got, choice["message"].(map[string]interface{})["content"].(string))
+	}
+	if want := []string{"a", "bc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("choices = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateChatStreamEmpty(t *testing.T) {
+	if _, err := aggregateChatStream(strings.NewReader("data: [DONE]\n\n")); err == nil {
+		t.Errorf("空的流式响应应返回错误")
+	}
+}
@@ -0,0 +1,692 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HandleMessages 处理Anthropic Messages API请求，转换为chat/completions后转发到后端
+func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAnthropicError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	anthropicReq, err := h.decodeJSONBody(r)
+	if err != nil {
+		writeAnthropicError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestedStream, _ := anthropicReq["stream"].(bool)
+	chatReq, err := anthropicToChatRequest(anthropicReq)
+	if err != nil {
+		writeAnthropicError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upstream, err := h.forwardChatCompletion(r, chatReq)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if pe, ok := err.(*proxyError); ok {
+			status = pe.status
+		}
+		writeAnthropicError(w, err.Error(), status)
+		return
+	}
+	resp := upstream.resp
+	defer resp.Body.Close()
+	customModelID := upstream.model
+	upstream.setContextHeaders(w)
+	clientStream := upstream.clientStream(requestedStream)
+	defer h.recordUsage(r, upstream, clientStream)
+
+	if resp.StatusCode >= 400 {
+		errorBody, _ := io.ReadAll(resp.Body)
+		apiErr := normalizeUpstreamError(resp.StatusCode, errorBody)
+		writeAnthropicError(w, apiErr.message, apiErr.status)
+		return
+	}
+	if clientStream {
+		if err := streamChatAsAnthropic(w, upstream.eachChunk, customModelID); err != nil && h.logger != nil && r.Context().Err() == nil {
+			h.logger.Error("Anthropic流式响应处理失败: %v", err)
+		}
+		return
+	}
+
+	chatResp, err := upstream.readJSON()
+	if err != nil {
+		writeAnthropicError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	h.writeJSON(w, chatToAnthropicResponse(chatResp, customModelID))
+}
+
+// anthropicToChatRequest 将Anthropic Messages请求转换为OpenAI chat/completions请求
+func anthropicToChatRequest(req map[string]interface{}) (map[string]interface{}, error) {
+	chatReq := map[string]interface{}{}
+
+	model, _ := req["model"].(string)
+	chatReq["model"] = model
+
+	var messages []interface{}
+	if system := anthropicText(req["system"]); system != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": system})
+	}
+
+	rawMessages, ok := req["messages"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("messages 字段必须为数组")
+	}
+	for _, raw := range rawMessages {
+		msg, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("无效的消息格式")
+		}
+		converted, err := anthropicMessageToChat(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, converted...)
+	}
+	chatReq["messages"] = messages
+
+	if v, ok := req["max_tokens"]; ok {
+		chatReq["max_tokens"] = v
+	}
+	for _, key := range []string{"temperature", "top_p", "stream"} {
+		if v, ok := req[key]; ok {
+			chatReq[key] = v
+		}
+	}
+	if stop, ok := req["stop_sequences"].([]interface{}); ok && len(stop) > 0 {
+		chatReq["stop"] = stop
+	}
+	if metadata, ok := req["metadata"].(map[string]interface{}); ok {
+		if userID, ok := metadata["user_id"].(string); ok && userID != "" {
+			chatReq["user"] = userID
+		}
+	}
+
+	if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 {
+		var chatTools []interface{}
+		for _, raw := range tools {
+			tool, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			function := map[string]interface{}{"name": tool["name"]}
+			if desc, ok := tool["description"]; ok {
+				function["description"] = desc
+			}
+			if schema, ok := tool["input_schema"]; ok {
+				function["parameters"] = schema
+			}
+			chatTools = append(chatTools, map[string]interface{}{"type": "function", "function": function})
+		}
+		chatReq["tools"] = chatTools
+	}
+
+	if choice, ok := req["tool_choice"].(map[string]interface{}); ok {
+		switch choice["type"] {
+		case "auto":
+			chatReq["tool_choice"] = "auto"
+		case "any":
+			chatReq["tool_choice"] = "required"
+		case "none":
+			chatReq["tool_choice"] = "none"
+		case "tool":
+			chatReq["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": choice["name"]},
+			}
+		}
+		if disable, ok := choice["disable_parallel_tool_use"].(bool); ok && disable {
+			chatReq["parallel_tool_calls"] = false
+		}
+	}
+
+	return chatReq, nil
+}
+
+// anthropicMessageToChat 转换单条Anthropic消息，tool_result会拆分为独立的tool消息
+func anthropicMessageToChat(msg map[string]interface{}) ([]interface{}, error) {
+	role, _ := msg["role"].(string)
+	if role != "user" && role != "assistant" {
+		return nil, fmt.Errorf("不支持的消息角色: %s", role)
+	}
+
+	if text, ok := msg["content"].(string); ok {
+		return []interface{}{map[string]interface{}{"role": role, "content": text}}, nil
+	}
+
+	blocks, ok := msg["content"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("无效的消息内容格式")
+	}
+
+	var out []interface{}
+	var parts []interface{}
+	var toolCalls []interface{}
+	hasImage := false
+
+	for _, raw := range blocks {
+		block, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			text, _ := block["text"].(string)
+			parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+		case "image":
+			if url := anthropicImageURL(block); url != "" {
+				hasImage = true
+				parts = append(parts, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]interface{}{"url": url},
+				})
+			}
+		case "tool_use":
+			arguments, _ := json.Marshal(block["input"])
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block["id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block["name"],
+					"arguments": string(arguments),
+				},
+			})
+		case "tool_result":
+			content := anthropicText(block["content"])
+			if isErr, _ := block["is_error"].(bool); isErr && content == "" {
+				content = "error"
+			}
+			out = append(out, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": block["tool_use_id"],
+				"content":      content,
+			})
+		}
+		// thinking/redacted_thinking 等块不转发给后端
+	}
+
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return out, nil
+	}
+
+	chatMsg := map[string]interface{}{"role": role}
+	if hasImage {
+		chatMsg["content"] = parts
+	} else {
+		var texts []string
+		for _, p := range parts {
+			texts = append(texts, p.(map[string]interface{})["text"].(string))
+		}
+		if len(texts) > 0 {
+			chatMsg["content"] = strings.Join(texts, "\n")
+		} else {
+			chatMsg["content"] = nil
+		}
+	}
+	if len(toolCalls) > 0 {
+		chatMsg["tool_calls"] = toolCalls
+	}
+	return append(out, chatMsg), nil
+}
+
+// anthropicText 提取字符串或text块数组中的文本
+func anthropicText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []interface{}:
+		var texts []string
+		for _, raw := range val {
+			if block, ok := raw.(map[string]interface{}); ok && block["type"] == "text" {
+				if text, ok := block["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// anthropicImageURL 将Anthropic图片块转换为data URL或普通URL
+func anthropicImageURL(block map[string]interface{}) string {
+	source, ok := block["source"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	switch source["type"] {
+	case "base64":
+		mediaType, _ := source["media_type"].(string)
+		data, _ := source["data"].(string)
+		return fmt.Sprintf("data:%s;base64,%s", mediaType, data)
+	case "url":
+		url, _ := source["url"].(string)
+		return url
+	}
+	return ""
+}
+
+// chatToAnthropicResponse 将chat.completion响应转换为Anthropic message
+func chatToAnthropicResponse(chatResp map[string]interface{}, model string) map[string]interface{} {
+	var content []interface{}
+	stopReason := "end_turn"
+
+	if choices, ok := chatResp["choices"].([]interface{}); ok && len(choices) > 0 {
+		choice, _ := choices[0].(map[string]interface{})
+		message, _ := choice["message"].(map[string]interface{})
+		if reasoning, ok := message["reasoning_content"].(string); ok && reasoning != "" {
+			content = append(content, map[string]interface{}{"type": "thinking", "thinking": reasoning, "signature": ""})
+		}
+		if text, ok := message["content"].(string); ok && text != "" {
+			content = append(content, map[string]interface{}{"type": "text", "text": text})
+		}
+		if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
+			for _, raw := range toolCalls {
+				call, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				function, _ := call["function"].(map[string]interface{})
+				content = append(content, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  function["name"],
+					"input": parseToolArguments(function["arguments"]),
+				})
+			}
+		}
+		if reason, ok := choice["finish_reason"].(string); ok {
+			stopReason = anthropicStopReason(reason)
+		}
+	}
+	if content == nil {
+		content = []interface{}{}
+	}
+
+	inputTokens, outputTokens := chatUsageTokens(chatResp["usage"])
+	id, _ := chatResp["id"].(string)
+
+	return map[string]interface{}{
+		"id":            anthropicMessageID(id),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":  inputTokens,
+			"output_tokens": outputTokens,
+		},
+	}
+}
+
+// anthropicStreamState 将chat.completion.chunk流转换为Anthropic流事件时的状态
+// Anthropic的内容块按顺序输出，content_block_stop之后不能再有该块的delta；
+// 因此工具调用块打开后保持打开直到流结束，期间到达的正文、推理和其他工具调用先缓存，结束时按到达顺序输出
+type anthropicStreamState struct {
+	out          *sseWriter
+	model        string
+	started      bool
+	blockIndex   int    // 下一个内容块的序号
+	openType     string // 当前打开的块类型: thinking/text/tool_use
+	openIndex    int
+	liveTool     int                       // 正在流式输出参数的chat tool_calls序号，-1表示没有
+	deferred     []*anthropicDeferredBlock // 工具调用块打开期间缓存的内容块
+	stopReason   string
+	inputTokens  int
+	outputTokens int
+}
+
+// anthropicDeferredBlock 缓存的内容块，流结束时一次性输出
+type anthropicDeferredBlock struct {
+	blockType string // thinking/text/tool_use
+	callIndex int    // tool_use: chat tool_calls序号
+	id        interface{}
+	name      interface{}
+	text      strings.Builder // 推理、正文或工具调用参数
+}
+
+// streamChatAsAnthropic 读取后端chat chunk并输出Anthropic流事件
+// 后端流中断或没有返回任何chunk时发送Anthropic的error事件
+func streamChatAsAnthropic(w http.ResponseWriter, eachChunk func(fn func(chunk map[string]interface{}) error) error, model string) error {
+	out, err := newSSEWriter(w)
+	if err != nil {
+		return err
+	}
+	st := &anthropicStreamState{
+		out:        out,
+		model:      model,
+		liveTool:   -1,
+		stopReason: "end_turn",
+	}
+
+	if err := eachChunk(st.handleChunk); err != nil {
+		st.fail(err)
+		return err
+	}
+	if !st.started {
+		err := fmt.Errorf("后端返回了空的流式响应")
+		st.fail(err)
+		return err
+	}
+	return st.finish()
+}
+
+func (st *anthropicStreamState) handleChunk(chunk map[string]interface{}) error {
+	if !st.started {
+		id, _ := chunk["id"].(string)
+		if err := st.out.writeEvent("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":            anthropicMessageID(id),
+				"type":          "message",
+				"role":          "assistant",
+				"model":         st.model,
+				"content":       []interface{}{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+			},
+		}); err != nil {
+			return err
+		}
+		st.started = true
+	}
+
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		st.inputTokens, st.outputTokens = chatUsageTokens(usage)
+	}
+
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	delta, _ := choice["delta"].(map[string]interface{})
+
+	if reasoning, ok := delta["reasoning_content"].(string); ok && reasoning != "" {
+		if err := st.textDelta("thinking", reasoning); err != nil {
+			return err
+		}
+	}
+
+	if text, ok := delta["content"].(string); ok && text != "" {
+		if err := st.textDelta("text", text); err != nil {
+			return err
+		}
+	}
+
+	if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+		for _, raw := range toolCalls {
+			call, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := st.toolDelta(call); err != nil {
+				return err
+			}
+		}
+	}
+
+	if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+		st.stopReason = anthropicStopReason(reason)
+	}
+	return nil
+}
+
+// textDelta 输出推理或正文，工具调用块打开期间缓存
+func (st *anthropicStreamState) textDelta(blockType, text string) error {
+	if st.liveTool >= 0 {
+		last := len(st.deferred) - 1
+		if last < 0 || st.deferred[last].blockType != blockType {
+			st.deferred = append(st.deferred, &anthropicDeferredBlock{blockType: blockType})
+			last++
+		}
+		st.deferred[last].text.WriteString(text)
+		return nil
+	}
+
+	deltaType, field := "text_delta", "text"
+	if blockType == "thinking" {
+		deltaType, field = "thinking_delta", "thinking"
+	}
+	if err := st.ensureBlock(blockType, map[string]interface{}{"type": blockType, field: ""}); err != nil {
+		return err
+	}
+	return st.delta(st.openIndex, map[string]interface{}{"type": deltaType, field: text})
+}
+
+// toolDelta 输出工具调用：第一个工具调用流式输出参数，其余工具调用缓存到流结束
+func (st *anthropicStreamState) toolDelta(call map[string]interface{}) error {
+	callIndex := intValue(call["index"])
+	function, _ := call["function"].(map[string]interface{})
+	args, _ := function["arguments"].(string)
+
+	if st.liveTool < 0 {
+		if err := st.closeBlock(); err != nil {
+			return err
+		}
+		st.liveTool = callIndex
+		if err := st.openBlock("tool_use", map[string]interface{}{
+			"type":  "tool_use",
+			"id":    call["id"],
+			"name":  function["name"],
+			"input": map[string]interface{}{},
+		}); err != nil {
+			return err
+		}
+	}
+	if callIndex == st.liveTool {
+		if args == "" {
+			return nil
+		}
+		return st.delta(st.openIndex, map[string]interface{}{"type": "input_json_delta", "partial_json": args})
+	}
+
+	var block *anthropicDeferredBlock
+	for _, b := range st.deferred {
+		if b.blockType == "tool_use" && b.callIndex == callIndex {
+			block = b
+			break
+		}
+	}
+	if block == nil {
+		block = &anthropicDeferredBlock{blockType: "tool_use", callIndex: callIndex, id: call["id"], name: function["name"]}
+		st.deferred = append(st.deferred, block)
+	}
+	block.text.WriteString(args)
+	return nil
+}
+
+// ensureBlock 确保当前打开的块为指定类型，否则关闭当前块并新开一个
+func (st *anthropicStreamState) ensureBlock(blockType string, contentBlock map[string]interface{}) error {
+	if st.openType == blockType {
+		return nil
+	}
+	if err := st.closeBlock(); err != nil {
+		return err
+	}
+	return st.openBlock(blockType, contentBlock)
+}
+
+func (st *anthropicStreamState) openBlock(blockType string, contentBlock map[string]interface{}) error {
+	st.openType = blockType
+	st.openIndex = st.blockIndex
+	st.blockIndex++
+	return st.out.writeEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         st.openIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (st *anthropicStreamState) closeBlock() error {
+	if st.openType == "" {
+		return nil
+	}
+	st.openType = ""
+	return st.out.writeEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": st.openIndex,
+	})
+}
+
+func (st *anthropicStreamState) delta(index int, delta map[string]interface{}) error {
+	return st.out.writeEvent("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": delta,
+	})
+}
+
+// flushDeferred 关闭当前块后依次输出缓存的内容块
+func (st *anthropicStreamState) flushDeferred() error {
+	if err := st.closeBlock(); err != nil {
+		return err
+	}
+	st.liveTool = -1
+	for _, b := range st.deferred {
+		var contentBlock, delta map[string]interface{}
+		text := b.text.String()
+		switch b.blockType {
+		case "thinking":
+			contentBlock = map[string]interface{}{"type": "thinking", "thinking": ""}
+			delta = map[string]interface{}{"type": "thinking_delta", "thinking": text}
+		case "text":
+			contentBlock = map[string]interface{}{"type": "text", "text": ""}
+			delta = map[string]interface{}{"type": "text_delta", "text": text}
+		default:
+			contentBlock = map[string]interface{}{"type": "tool_use", "id": b.id, "name": b.name, "input": map[string]interface{}{}}
+			delta = map[string]interface{}{"type": "input_json_delta", "partial_json": text}
+		}
+		if err := st.openBlock(b.blockType, contentBlock); err != nil {
+			return err
+		}
+		if text != "" {
+			if err := st.delta(st.openIndex, delta); err != nil {
+				return err
+			}
+		}
+		if err := st.closeBlock(); err != nil {
+			return err
+		}
+	}
+	st.deferred = nil
+	return nil
+}
+
+// finish 输出剩余的内容块并发送message_delta和message_stop
+func (st *anthropicStreamState) finish() error {
+	if err := st.flushDeferred(); err != nil {
+		return err
+	}
+	if err := st.out.writeEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": st.stopReason, "stop_sequence": nil},
+		"usage": map[string]interface{}{"input_tokens": st.inputTokens, "output_tokens": st.outputTokens},
+	}); err != nil {
+		return err
+	}
+	return st.out.writeEvent("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+// fail 后端流中断时发送Anthropic的error事件；客户端可能已经断开，写入失败时忽略
+func (st *anthropicStreamState) fail(err error) {
+	st.out.writeEvent("error", map[string]interface{}{
+		"type":  "error",
+		"error": map[string]interface{}{"type": "api_error", "message": err.Error()},
+	})
+}
+
+// anthropicStopReason 将OpenAI finish_reason映射为Anthropic stop_reason
+func anthropicStopReason(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicMessageID 根据上游响应ID生成Anthropic消息ID
+func anthropicMessageID(id string) string {
+	if id == "" {
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return "msg_" + strings.TrimPrefix(id, "chatcmpl-")
+}
+
+// writeAnthropicError 写入Anthropic格式的错误响应
+func writeAnthropicError(w http.ResponseWriter, message string, statusCode int) {
+	errType := "api_error"
+	switch {
+	case statusCode == http.StatusBadRequest, statusCode == http.StatusMethodNotAllowed:
+		errType = "invalid_request_error"
+	case statusCode == http.StatusUnauthorized:
+		errType = "authentication_error"
+	case statusCode == http.StatusForbidden:
+		errType = "permission_error"
+	case statusCode == http.StatusNotFound:
+		errType = "not_found_error"
+	case statusCode == http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case statusCode == 529:
+		errType = "overloaded_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]interface{}{"type": errType, "message": message},
+	})
+}
+
+// parseToolArguments 将工具调用参数字符串解析为JSON对象
+func parseToolArguments(v interface{}) interface{} {
+	args, _ := v.(string)
+	if strings.TrimSpace(args) == "" {
+		return map[string]interface{}{}
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(args), &parsed); err != nil {
+		return map[string]interface{}{}
+	}
+	return parsed
+}
+
+// chatUsageTokens 读取chat usage中的输入、输出token数
+func chatUsageTokens(v interface{}) (int, int) {
+	usage, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, 0
+	}
+	return intValue(usage["prompt_tokens"]), intValue(usage["completion_tokens"])
+}
+
+// intValue 将JSON数字转换为int
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	}
+	return 0
+}
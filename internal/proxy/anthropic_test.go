@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// toolCallChunk 构造只包含一个工具调用delta的流式chunk，id为空时表示后续的参数片段
+func toolCallChunk(index int, id, name, args string) map[string]interface{} {
+	call := map[string]interface{}{
+		"index":    index,
+		"function": map[string]interface{}{"arguments": args},
+	}
+	if id != "" {
+		call["id"] = id
+		call["type"] = "function"
+		call["function"].(map[string]interface{})["name"] = name
+	}
+	return map[string]interface{}{
+		"choices": []interface{}{
+			map[string]interface{}{"index": 0, "delta": map[string]interface{}{"tool_calls": []interface{}{call}}},
+		},
+	}
+}
+
+// chunkSource 把chunk列表包装为eachChunk，全部输出后返回err
+func chunkSource(chunks []map[string]interface{}, err error) func(fn func(chunk map[string]interface{}) error) error {
+	return func(fn func(chunk map[string]interface{}) error) error {
+		for _, chunk := range chunks {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+// recordedEvents 解析写给客户端的SSE事件
+func recordedEvents(t *testing.T, body string) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	err := readSSEEvents(strings.NewReader(body), func(ev sseEvent) error {
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(ev.Data), &payload); err != nil {
+			return err
+		}
+		if payload["type"] != ev.Event {
+			t.Errorf("event %s 的type为 %v", ev.Event, payload["type"])
+		}
+		events = append(events, payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("解析SSE事件失败: %v", err)
+	}
+	return events
+}
+
+// anthropicBlock 客户端按事件还原的内容块
+type anthropicBlock struct {
+	Type string
+	ID   interface{}
+	Text string // 正文、推理或拼接后的工具参数
+}
+
+// replayAnthropic 按Anthropic协议还原内容块，同时检查块的开始、增量和结束顺序
+func replayAnthropic(t *testing.T, events []map[string]interface{}) ([]anthropicBlock, []string) {
+	t.Helper()
+	var blocks []anthropicBlock
+	var types []string
+	open := -1
+	for _, ev := range events {
+		typ := ev["type"].(string)
+		types = append(types, typ)
+		switch typ {
+		case "content_block_start":
+			index := intValue(ev["index"])
+			if open >= 0 || index != len(blocks) {
+				t.Fatalf("块%d在块%d结束前开始或序号不连续", index, open)
+			}
+			cb := ev["content_block"].(map[string]interface{})
+			blocks = append(blocks, anthropicBlock{Type: cb["type"].(string), ID: cb["id"]})
+			open = index
+		case "content_block_delta":
+			index := intValue(ev["index"])
+			if index != open {
+				t.Fatalf("向未打开的块%d发送了delta（当前打开的块为%d）", index, open)
+			}
+			delta := ev["delta"].(map[string]interface{})
+			for _, field := range []string{"text", "thinking", "partial_json"} {
+				if text, ok := delta[field].(string); ok {
+					blocks[index].Text += text
+				}
+			}
+		case "content_block_stop":
+			if intValue(ev["index"]) != open {
+				t.Fatalf("结束了未打开的块%v", ev["index"])
+			}
+			open = -1
+		}
+	}
+	if open >= 0 {
+		t.Errorf("块%d没有结束", open)
+	}
+	return blocks, types
+}
+
+func TestStreamChatAsAnthropic(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []map[string]interface{}
+		wantBlocks []anthropicBlock
+		wantStop   string
+	}{
+		{
+			name: "工具参数之间穿插正文",
+			chunks: []map[string]interface{}{
+				contentChunk("先看看", nil),
+				toolCallChunk(0, "call_1", "read", `{"path":`),
+				contentChunk("穿插的正文", nil),
+				toolCallChunk(0, "", "", `"a.go"}`),
+				contentChunk("", "tool_calls"),
+			},
+			wantBlocks: []anthropicBlock{
+				{Type: "text", Text: "先看看"},
+				{Type: "tool_use", ID: "call_1", Text: `{"path":"a.go"}`},
+				{Type: "text", Text: "穿插的正文"},
+			},
+			wantStop: "tool_use",
+		},
+		{
+			name: "并行工具调用的参数交替到达",
+			chunks: []map[string]interface{}{
+				toolCallChunk(0, "call_1", "a", `{"x"`),
+				toolCallChunk(1, "call_2", "b", `{"y"`),
+				toolCallChunk(0, "", "", `:1}`),
+				toolCallChunk(1, "", "", `:2}`),
+				contentChunk("", "tool_calls"),
+			},
+			wantBlocks: []anthropicBlock{
+				{Type: "tool_use", ID: "call_1", Text: `{"x":1}`},
+				{Type: "tool_use", ID: "call_2", Text: `{"y":2}`},
+			},
+			wantStop: "tool_use",
+		},
+		{
+			name: "推理和正文",
+			chunks: []map[string]interface{}{
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"reasoning_content": "想"}}}},
+				contentChunk("答", nil),
+				contentChunk("", "length"),
+			},
+			wantBlocks: []anthropicBlock{
+				{Type: "thinking", Text: "想"},
+				{Type: "text", Text: "答"},
+			},
+			wantStop: "max_tokens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := streamChatAsAnthropic(w, chunkSource(tt.chunks, nil), "claude-test"); err != nil {
+				t.Fatalf("streamChatAsAnthropic: %v", err)
+			}
+			events := recordedEvents(t, w.Body.String())
+			blocks, types := replayAnthropic(t, events)
+			if !reflect.DeepEqual(blocks, tt.wantBlocks) {
+				t.Errorf("blocks = %+v, want %+v", blocks, tt.wantBlocks)
+			}
+			if types[0] != "message_start" || types[len(types)-1] != "message_stop" {
+				t.Errorf("事件顺序 = %v", types)
+			}
+			messageDelta := events[len(events)-2]
+			if reason := messageDelta["delta"].(map[string]interface{})["stop_reason"]; reason != tt.wantStop {
+				t.Errorf("stop_reason = %v, want %s", reason, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestStreamChatAsAnthropicUpstreamError(t *testing.T) {
+	upstreamErr := errors.New("读取流数据失败: unexpected EOF")
+	tests := []struct {
+		name      string
+		chunks    []map[string]interface{}
+		err       error
+		wantTypes []string
+	}{
+		{
+			name:      "第一个chunk之前出错",
+			err:       upstreamErr,
+			wantTypes: []string{"error"},
+		},
+		{
+			name:      "没有任何chunk",
+			wantTypes: []string{"error"},
+		},
+		{
+			name:      "输出中途出错",
+			chunks:    []map[string]interface{}{contentChunk("部分", nil)},
+			err:       upstreamErr,
+			wantTypes: []string{"message_start", "content_block_start", "content_block_delta", "error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := streamChatAsAnthropic(w, chunkSource(tt.chunks, tt.err), "claude-test"); err == nil {
+				t.Errorf("应返回错误")
+			}
+			events := recordedEvents(t, w.Body.String())
+			var types []string
+			for _, ev := range events {
+				types = append(types, ev["type"].(string))
+			}
+			if !reflect.DeepEqual(types, tt.wantTypes) {
+				t.Fatalf("事件 = %v, want %v", types, tt.wantTypes)
+			}
+			apiErr := events[len(events)-1]["error"].(map[string]interface{})
+			if apiErr["type"] != "api_error" || apiErr["message"] == "" {
+				t.Errorf("error = %v", apiErr)
+			}
+		})
+	}
+}
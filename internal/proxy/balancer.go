@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"trae-proxy-go/pkg/models"
+)
+
+// 负载均衡策略
+const (
+	strategyRoundRobin     = "round_robin"
+	strategyWeightedRandom = "weighted_random"
+	strategyLeastInFlight  = "least_in_flight"
+)
+
+// balancer 在共用同一个自定义模型ID的后端之间分配请求
+// 相同priority的后端按策略排序，不同priority之间仍按优先级依次故障转移
+type balancer struct {
+	strategies map[string]string // 自定义模型ID -> 策略
+
+	mu       sync.Mutex
+	counters map[string]uint64 // 轮询计数，按自定义模型ID
+	inFlight map[*models.API]*int64
+}
+
+func newBalancer(strategies map[string]string) *balancer {
+	return &balancer{
+		strategies: strategies,
+		counters:   map[string]uint64{},
+		inFlight:   map[*models.API]*int64{},
+	}
+}
+
+// order 按负载均衡策略调整候选后端顺序
+func (b *balancer) order(modelID string, candidates []*models.API) []*models.API {
+	strategy := b.strategies[modelID]
+	if strategy == "" || len(candidates) < 2 {
+		return candidates
+	}
+
+	ordered := make([]*models.API, 0, len(candidates))
+	for start := 0; start < len(candidates); {
+		end := start + 1
+		for end < len(candidates) && candidates[end].Priority == candidates[start].Priority {
+			end++
+		}
+		group := append([]*models.API(nil), candidates[start:end]...)
+		if len(group) > 1 {
+			switch strategy {
+			case strategyRoundRobin:
+				group = b.rotate(modelID, group)
+			case strategyWeightedRandom:
+				group = weightedShuffle(group)
+			case strategyLeastInFlight:
+				// 先轮转再稳定排序，使并发数相同的后端轮流被选中
+				group = b.rotate(modelID, group)
+				sort.SliceStable(group, func(i, j int) bool {
+					return b.current(group[i]) < b.current(group[j])
+				})
+			}
+		}
+		ordered = append(ordered, group...)
+		start = end
+	}
+	return ordered
+}
+
+// rotate 按轮询计数轮转后端顺序
+func (b *balancer) rotate(modelID string, group []*models.API) []*models.API {
+	b.mu.Lock()
+	n := b.counters[modelID]
+	b.counters[modelID]++
+	b.mu.Unlock()
+
+	offset := int(n % uint64(len(group)))
+	return append(group[offset:], group[:offset]...)
+}
+
+// weightedShuffle 按权重进行不放回的随机抽样，得到完整的尝试顺序
+func weightedShuffle(group []*models.API) []*models.API {
+	remaining := append([]*models.API(nil), group...)
+	ordered := make([]*models.API, 0, len(group))
+	for len(remaining) > 0 {
+		total := 0
+		for _, api := range remaining {
+			total += apiWeight(api)
+		}
+		pick := rand.Intn(total)
+		idx := 0
+		for i, api := range remaining {
+			pick -= apiWeight(api)
+			if pick < 0 {
+				idx = i
+				break
+			}
+		}
+		ordered = append(ordered, remaining[idx])
+		remaining = append(remaining[:idx], remaining[idx+1:]...)
+	}
+	return ordered
+}
+
+// apiWeight 返回后端权重，未配置时为1
+func apiWeight(api *models.API) int {
+	if api.Weight <= 0 {
+		return 1
+	}
+	return api.Weight
+}
+
+// counter 返回后端的并发计数器
+func (b *balancer) counter(api *models.API) *int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.inFlight[api]
+	if !ok {
+		c = new(int64)
+		b.inFlight[api] = c
+	}
+	return c
+}
+
+// current 返回后端当前的并发请求数
+func (b *balancer) current(api *models.API) int64 {
+	return atomic.LoadInt64(b.counter(api))
+}
+
+// acquire 增加后端的并发计数，返回的函数用于释放，可重复调用
+func (b *balancer) acquire(api *models.API) func() {
+	c := b.counter(api)
+	atomic.AddInt64(c, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(c, -1) })
+	}
+}
+
+// releaseOnClose 在响应体关闭时释放并发计数
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"trae-proxy-go/pkg/models"
+)
+
+// balancerAPIs 构造共用同一个自定义模型ID的后端，名为off的后端未激活
+func balancerAPIs(apis ...models.API) *models.Config {
+	for i := range apis {
+		apis[i].CustomModelID = "m"
+		if apis[i].Name != "off" {
+			apis[i].Active = true
+		}
+	}
+	return &models.Config{APIs: apis}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	cfg := balancerAPIs(
+		models.API{Name: "a", Priority: 1},
+		models.API{Name: "b", Priority: 1},
+		models.API{Name: "c", Priority: 1},
+		models.API{Name: "backup", Priority: 2},
+	)
+	candidates := newRouter(cfg).modelGroup("m")
+	b := newBalancer(map[string]string{"m": strategyRoundRobin})
+
+	want := [][]string{
+		{"a", "b", "c", "backup"},
+		{"b", "c", "a", "backup"},
+		{"c", "a", "b", "backup"},
+		{"a", "b", "c", "backup"},
+	}
+	for i, w := range want {
+		if got := candidateNames(b.order("m", candidates)); !reflect.DeepEqual(got, w) {
+			t.Errorf("第%d次 order = %v, want %v", i, got, w)
+		}
+	}
+	if got := candidateNames(candidates); !reflect.DeepEqual(got, []string{"a", "b", "c", "backup"}) {
+		t.Errorf("order不应修改传入的候选列表: %v", got)
+	}
+}
+
+func TestBalancerWeightedRandom(t *testing.T) {
+	tests := []struct {
+		name    string
+		apis    []models.API
+		wantPct map[string]float64 // 各后端排在第一位的期望比例
+	}{
+		{
+			name:    "按权重分配",
+			apis:    []models.API{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}},
+			wantPct: map[string]float64{"a": 0.75, "b": 0.25},
+		},
+		{
+			name:    "未配置权重时按1计算",
+			apis:    []models.API{{Name: "a"}, {Name: "b", Weight: 1}},
+			wantPct: map[string]float64{"a": 0.5, "b": 0.5},
+		},
+		{
+			name:    "未激活的后端不参与分配",
+			apis:    []models.API{{Name: "a", Weight: 1}, {Name: "off", Weight: 100}, {Name: "b", Weight: 1}},
+			wantPct: map[string]float64{"a": 0.5, "b": 0.5},
+		},
+	}
+
+	const rounds = 4000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := newRouter(balancerAPIs(tt.apis...)).modelGroup("m")
+			b := newBalancer(map[string]string{"m": strategyWeightedRandom})
+			first := map[string]int{}
+			for i := 0; i < rounds; i++ {
+				ordered := b.order("m", candidates)
+				if len(ordered) != len(tt.wantPct) {
+					t.Fatalf("候选后端 = %v", candidateNames(ordered))
+				}
+				first[ordered[0].Name]++
+			}
+			for name, want := range tt.wantPct {
+				if got := float64(first[name]) / rounds; math.Abs(got-want) > 0.05 {
+					t.Errorf("%s 排在第一位的比例 = %.3f, want %.2f", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancerLeastInFlight(t *testing.T) {
+	cfg := balancerAPIs(models.API{Name: "a"}, models.API{Name: "b"}, models.API{Name: "c"})
+	candidates := newRouter(cfg).modelGroup("m")
+	b := newBalancer(map[string]string{"m": strategyLeastInFlight})
+
+	releaseA := b.acquire(candidates[0])
+	releaseA2 := b.acquire(candidates[0])
+	releaseB := b.acquire(candidates[1])
+	if got := candidateNames(b.order("m", candidates)); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("order = %v, want [c b a]", got)
+	}
+
+	releaseA()
+	releaseA() // 重复释放不影响计数
+	releaseA2()
+	releaseB()
+	if n := b.current(candidates[0]); n != 0 {
+		t.Errorf("释放后并发数 = %d", n)
+	}
+	// 并发数相同时轮流排在第一位
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[b.order("m", candidates)[0].Name] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("并发数相同时排在第一位的后端 = %v", seen)
+	}
+}
+
+func TestBalancerNoStrategy(t *testing.T) {
+	candidates := newRouter(balancerAPIs(models.API{Name: "a"}, models.API{Name: "b"})).modelGroup("m")
+	b := newBalancer(map[string]string{"other": strategyRoundRobin})
+	for i := 0; i < 3; i++ {
+		if got := candidateNames(b.order("m", candidates)); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("未配置策略时 order = %v, want [a b]", got)
+		}
+	}
+}
+
+func TestReleaseOnClose(t *testing.T) {
+	b := newBalancer(nil)
+	api := &models.API{Name: "a"}
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader("ok"))}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: b.acquire(api)}
+	if n := b.current(api); n != 1 {
+		t.Fatalf("并发数 = %d, want 1", n)
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if n := b.current(api); n != 0 {
+		t.Errorf("关闭响应体后并发数 = %d, want 0", n)
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/internal/logger"
+	"trae-proxy-go/pkg/models"
+)
+
+// 熔断器状态
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// 熔断器默认参数
+const (
+	defaultBreakerWindow       = 20
+	defaultBreakerMinRequests  = 10
+	defaultBreakerOpenDuration = 30 * time.Second
+)
+
+// breakerOutcome 一次请求对熔断器的影响
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+	breakerIgnore // 请求未真正发往后端，只释放半开状态的试探名额
+)
+
+// circuitBreaker 单个后端的熔断器
+// closed: 正常放行；open: 拒绝请求直到open_duration结束；half_open: 只放行一个试探请求，成功则恢复，失败则重新熔断
+type circuitBreaker struct {
+	cfg models.CircuitBreaker
+
+	mu          sync.Mutex
+	state       string
+	since       time.Time
+	consecutive int
+	results     []bool // 最近请求是否失败的环形缓冲
+	next        int
+	trialing    bool
+}
+
+func newCircuitBreaker(cfg models.CircuitBreaker) *circuitBreaker {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultBreakerWindow
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultBreakerMinRequests
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = defaultBreakerOpenDuration
+	}
+	return &circuitBreaker{cfg: cfg, state: breakerClosed, since: time.Now()}
+}
+
+// allow 判断是否放行请求，open状态到期后转为half_open并放行一个试探请求
+func (b *circuitBreaker) allow() (allowed bool, from, to string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from = b.state
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.since) < b.cfg.OpenDuration {
+			return false, from, b.state
+		}
+		b.setState(breakerHalfOpen)
+		b.trialing = true
+		return true, from, b.state
+	case breakerHalfOpen:
+		if b.trialing {
+			return false, from, b.state
+		}
+		b.trialing = true
+		return true, from, b.state
+	}
+	return true, from, b.state
+}
+
+// record 记录请求结果，返回状态变化
+func (b *circuitBreaker) record(outcome breakerOutcome) (from, to string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from = b.state
+	if b.state == breakerHalfOpen {
+		b.trialing = false
+		switch outcome {
+		case breakerSuccess:
+			b.reset()
+			b.setState(breakerClosed)
+		case breakerFailure:
+			b.setState(breakerOpen)
+		}
+		return from, b.state
+	}
+	if outcome == breakerIgnore || b.state != breakerClosed {
+		return from, b.state
+	}
+
+	failed := outcome == breakerFailure
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	if len(b.results) < b.cfg.Window {
+		b.results = append(b.results, failed)
+	} else {
+		b.results[b.next] = failed
+		b.next = (b.next + 1) % b.cfg.Window
+	}
+
+	if failed && b.shouldOpen() {
+		b.setState(breakerOpen)
+	}
+	return from, b.state
+}
+
+// shouldOpen 判断是否达到熔断阈值
+func (b *circuitBreaker) shouldOpen() bool {
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.ErrorRate > 0 && len(b.results) >= b.cfg.MinRequests {
+		failures := 0
+		for _, failed := range b.results {
+			if failed {
+				failures++
+			}
+		}
+		return float64(failures)/float64(len(b.results)) >= b.cfg.ErrorRate
+	}
+	return false
+}
+
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	b.since = time.Now()
+}
+
+func (b *circuitBreaker) reset() {
+	b.consecutive = 0
+	b.results = b.results[:0]
+	b.next = 0
+}
+
+// status 返回当前状态快照
+func (b *circuitBreaker) status() models.BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return models.BreakerStatus{State: b.state, Since: b.since, ConsecutiveFailures: b.consecutive}
+}
+
+// breakerSet 管理所有后端的熔断器，并在状态变化时记录日志、写出状态文件
+type breakerSet struct {
+	logger    *logger.Logger
+	backends  []*models.API
+	breakers  map[*models.API]*circuitBreaker
+	fileMu    sync.Mutex
+	statePath string
+}
+
+func newBreakerSet(cfg *models.Config, logger *logger.Logger) *breakerSet {
+	s := &breakerSet{logger: logger, breakers: map[*models.API]*circuitBreaker{}}
+	for i := range cfg.APIs {
+		api := &cfg.APIs[i]
+		cbCfg := api.CircuitBreaker
+		if cbCfg == nil {
+			cbCfg = cfg.CircuitBreaker
+		}
+		if cbCfg == nil || (cbCfg.ConsecutiveFailures == 0 && cbCfg.ErrorRate == 0) {
+			continue
+		}
+		s.backends = append(s.backends, api)
+		s.breakers[api] = newCircuitBreaker(*cbCfg)
+	}
+	return s
+}
+
+// setStatePath 设置状态文件路径并立即写出当前状态
+func (s *breakerSet) setStatePath(path string) {
+	s.fileMu.Lock()
+	s.statePath = path
+	s.fileMu.Unlock()
+	s.persist()
+}
+
+// allow 判断后端是否可以接收请求，未配置熔断器的后端总是放行
+func (s *breakerSet) allow(api *models.API) bool {
+	b, ok := s.breakers[api]
+	if !ok {
+		return true
+	}
+	allowed, from, to := b.allow()
+	s.transition(api, from, to)
+	return allowed
+}
+
+// record 记录后端请求结果
+func (s *breakerSet) record(api *models.API, outcome breakerOutcome) {
+	b, ok := s.breakers[api]
+	if !ok {
+		return
+	}
+	from, to := b.record(outcome)
+	s.transition(api, from, to)
+}
+
+// transition 记录状态变化
+func (s *breakerSet) transition(api *models.API, from, to string) {
+	if from == to {
+		return
+	}
+	if s.logger != nil {
+		if to == breakerOpen {
+			s.logger.Error("后端 %s 熔断器状态: %s -> %s", api.Name, from, to)
+		} else {
+			s.logger.Info("后端 %s 熔断器状态: %s -> %s", api.Name, from, to)
+		}
+	}
+	s.persist()
+}
+
+// persist 写出所有熔断器的状态快照
+func (s *breakerSet) persist() {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	if s.statePath == "" {
+		return
+	}
+
+	state := &models.BreakerStateFile{UpdatedAt: time.Now(), Backends: map[string]models.BreakerStatus{}}
+	for _, api := range s.backends {
+		state.Backends[api.Name] = s.breakers[api].status()
+	}
+	if err := config.SaveBreakerState(s.statePath, state); err != nil && s.logger != nil {
+		s.logger.Error("%v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+// breakerStep 熔断器测试中的一步操作及其后的期望状态
+type breakerStep struct {
+	op          string // allow、success、failure、ignore，cooldown表示open_duration已过去
+	wantAllowed bool   // 只对allow有效
+	wantState   string
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   models.CircuitBreaker
+		steps []breakerStep
+	}{
+		{
+			name: "连续失败达到阈值后熔断",
+			cfg:  models.CircuitBreaker{ConsecutiveFailures: 2},
+			steps: []breakerStep{
+				{op: "failure", wantState: breakerClosed},
+				{op: "success", wantState: breakerClosed},
+				{op: "failure", wantState: breakerClosed},
+				{op: "failure", wantState: breakerOpen},
+				{op: "allow", wantAllowed: false, wantState: breakerOpen},
+			},
+		},
+		{
+			name: "冷却结束后试探成功恢复",
+			cfg:  models.CircuitBreaker{ConsecutiveFailures: 1},
+			steps: []breakerStep{
+				{op: "failure", wantState: breakerOpen},
+				{op: "cooldown", wantState: breakerOpen},
+				{op: "allow", wantAllowed: true, wantState: breakerHalfOpen},
+				{op: "allow", wantAllowed: false, wantState: breakerHalfOpen},
+				{op: "success", wantState: breakerClosed},
+				{op: "allow", wantAllowed: true, wantState: breakerClosed},
+				{op: "failure", wantState: breakerOpen},
+			},
+		},
+		{
+			name: "试探失败重新熔断并重新计时",
+			cfg:  models.CircuitBreaker{ConsecutiveFailures: 1},
+			steps: []breakerStep{
+				{op: "failure", wantState: breakerOpen},
+				{op: "cooldown", wantState: breakerOpen},
+				{op: "allow", wantAllowed: true, wantState: breakerHalfOpen},
+				{op: "failure", wantState: breakerOpen},
+				{op: "allow", wantAllowed: false, wantState: breakerOpen},
+			},
+		},
+		{
+			name: "未发出的试探请求释放名额",
+			cfg:  models.CircuitBreaker{ConsecutiveFailures: 1},
+			steps: []breakerStep{
+				{op: "failure", wantState: breakerOpen},
+				{op: "cooldown", wantState: breakerOpen},
+				{op: "allow", wantAllowed: true, wantState: breakerHalfOpen},
+				{op: "ignore", wantState: breakerHalfOpen},
+				{op: "allow", wantAllowed: true, wantState: breakerHalfOpen},
+			},
+		},
+		{
+			name: "错误率在请求数不足时不熔断",
+			cfg:  models.CircuitBreaker{ErrorRate: 0.5, MinRequests: 4, Window: 4},
+			steps: []breakerStep{
+				{op: "failure", wantState: breakerClosed},
+				{op: "failure", wantState: breakerClosed},
+				{op: "success", wantState: breakerClosed},
+				{op: "failure", wantState: breakerOpen},
+			},
+		},
+		{
+			name: "错误率只统计最近window个请求",
+			cfg:  models.CircuitBreaker{ErrorRate: 0.5, MinRequests: 2, Window: 3},
+			steps: []breakerStep{
+				{op: "success", wantState: breakerClosed},
+				{op: "success", wantState: breakerClosed},
+				{op: "success", wantState: breakerClosed},
+				{op: "failure", wantState: breakerClosed}, // 1/3
+				{op: "failure", wantState: breakerOpen},   // 2/3
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(tt.cfg)
+			for i, step := range tt.steps {
+				switch step.op {
+				case "allow":
+					if allowed, _, _ := b.allow(); allowed != step.wantAllowed {
+						t.Fatalf("第%d步 allow = %v, want %v", i, allowed, step.wantAllowed)
+					}
+				case "success":
+					b.record(breakerSuccess)
+				case "failure":
+					b.record(breakerFailure)
+				case "ignore":
+					b.record(breakerIgnore)
+				case "cooldown":
+					b.since = b.since.Add(-b.cfg.OpenDuration)
+				}
+				if state := b.status().State; state != step.wantState {
+					t.Fatalf("第%d步(%s)后状态 = %s, want %s", i, step.op, state, step.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOpenDuration(t *testing.T) {
+	b := newCircuitBreaker(models.CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Minute})
+	b.record(breakerFailure)
+
+	b.since = time.Now().Add(-59 * time.Second)
+	if allowed, _, _ := b.allow(); allowed {
+		t.Errorf("冷却未结束时不应放行")
+	}
+	b.since = time.Now().Add(-time.Minute)
+	if allowed, from, to := b.allow(); !allowed || from != breakerOpen || to != breakerHalfOpen {
+		t.Errorf("冷却结束后 allow = %v, %s -> %s", allowed, from, to)
+	}
+}
+
+func TestBreakerSetPersist(t *testing.T) {
+	cfg := &models.Config{
+		CircuitBreaker: &models.CircuitBreaker{ConsecutiveFailures: 2},
+		APIs: []models.API{
+			{Name: "a", Active: true},
+			{Name: "b", Active: true, CircuitBreaker: &models.CircuitBreaker{ConsecutiveFailures: 1}},
+			{Name: "c", Active: true, CircuitBreaker: &models.CircuitBreaker{}}, // 阈值都为0，不启用
+		},
+	}
+	s := newBreakerSet(cfg, nil)
+	path := config.BreakerStatePath(filepath.Join(t.TempDir(), "config.yaml"))
+	s.setStatePath(path)
+
+	s.record(&cfg.APIs[0], breakerFailure)
+	s.record(&cfg.APIs[1], breakerFailure)
+	s.record(&cfg.APIs[2], breakerFailure)
+	if !s.allow(&cfg.APIs[2]) {
+		t.Errorf("未启用熔断器的后端应总是放行")
+	}
+
+	state, err := config.LoadBreakerState(path)
+	if err != nil {
+		t.Fatalf("LoadBreakerState: %v", err)
+	}
+	if state == nil {
+		t.Fatalf("状态文件未写出")
+	}
+	tests := []struct {
+		backend     string
+		wantState   string
+		wantFailure int
+	}{
+		{"a", breakerClosed, 1},
+		{"b", breakerOpen, 1},
+	}
+	for _, tt := range tests {
+		status, ok := state.Backends[tt.backend]
+		if !ok {
+			t.Errorf("状态文件中缺少后端 %s", tt.backend)
+			continue
+		}
+		if status.State != tt.wantState || status.ConsecutiveFailures != tt.wantFailure {
+			t.Errorf("后端 %s 状态 = %+v, want %s/%d", tt.backend, status, tt.wantState, tt.wantFailure)
+		}
+	}
+	if _, ok := state.Backends["c"]; ok || len(state.Backends) != 2 {
+		t.Errorf("状态文件中的后端 = %v", state.Backends)
+	}
+}
+
+func TestLoadBreakerStateMissing(t *testing.T) {
+	state, err := config.LoadBreakerState(filepath.Join(t.TempDir(), "breaker_state.json"))
+	if err != nil || state != nil {
+		t.Errorf("文件不存在时 LoadBreakerState = %v, %v, want nil, nil", state, err)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import "trae-proxy-go/pkg/models"
+
+// applyCompatProfile 按兼容性配置调整请求体: 映射消息角色、字段改名、删除字段、限制数值范围
+// 消息列表可能与原始请求或Responses存储共享，修改角色时复制消息而不是原地修改
+func applyCompatProfile(reqJSON map[string]interface{}, profile *models.CompatProfile) {
+	if profile == nil {
+		return
+	}
+
+	if len(profile.Roles) > 0 {
+		if messages, ok := reqJSON["messages"].([]interface{}); ok {
+			translated := make([]interface{}, len(messages))
+			for i, raw := range messages {
+				translated[i] = raw
+				message, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				role, _ := message["role"].(string)
+				if mapped, ok := profile.Roles[role]; ok {
+					message = copyMap(message)
+					message["role"] = mapped
+					translated[i] = message
+				}
+			}
+			reqJSON["messages"] = translated
+		}
+	}
+
+	for from, to := range profile.Rename {
+		value, ok := reqJSON[from]
+		if !ok {
+			continue
+		}
+		delete(reqJSON, from)
+		// 客户端同时设置了新旧字段时保留新字段
+		if _, exists := reqJSON[to]; !exists {
+			reqJSON[to] = value
+		}
+	}
+
+	for _, key := range profile.Drop {
+		delete(reqJSON, key)
+	}
+
+	for key, r := range profile.Clamp {
+		value, ok := toFloat(reqJSON[key])
+		if !ok {
+			continue
+		}
+		if r.Min != nil && value < *r.Min {
+			reqJSON[key] = *r.Min
+		}
+		if r.Max != nil && value > *r.Max {
+			reqJSON[key] = *r.Max
+		}
+	}
+}
+
+// toFloat 将JSON或YAML中的数值转换为float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"trae-proxy-go/pkg/models"
+)
+
+// 上下文裁剪后写入响应的头
+const (
+	headerContextTruncated = "X-Context-Truncated"        // 发生裁剪时为true
+	headerContextDropped   = "X-Context-Dropped-Messages" // 删除的消息数
+	headerContextSummary   = "X-Context-Summarized"       // 被删除的对话已摘要时为true
+)
+
+// maxSummaryCacheEntries 摘要缓存的最大条目数，超过时清空
+const maxSummaryCacheEntries = 256
+
+// summaryPrompt 生成摘要时使用的系统提示词
+const summaryPrompt = "你是对话摘要助手。请把用户提供的对话记录压缩为简洁的摘要，保留关键事实、需求、已做出的决定、涉及的文件名与代码要点以及工具调用结果，不要添加对话中没有的内容。直接输出摘要。"
+
+// contextResult 上下文裁剪结果
+type contextResult struct {
+	dropped    int  // 删除的消息数
+	summarized bool // 删除的对话是否已替换为摘要
+}
+
+// summaryCache 缓存对话摘要，同一请求重试或故障转移时不重复调用摘要后端
+type summaryCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func newSummaryCache() *summaryCache {
+	return &summaryCache{entries: map[string]string{}}
+}
+
+func (c *summaryCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary, ok := c.entries[key]
+	return summary, ok
+}
+
+func (c *summaryCache) put(key, summary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxSummaryCacheEntries {
+		c.entries = map[string]string{}
+	}
+	c.entries[key] = summary
+}
+
+// fitContext 估算的输入token数超出后端上下文窗口时，从最早的对话轮次开始删除，
+// 保留所有系统消息和最近keep_turns轮对话；strategy为summarize时把删除的对话摘要后插入到系统消息之后
+func (h *Handler) fitContext(r *http.Request, backend *models.API, reqJSON map[string]interface{}) contextResult {
+	c := backend.Context
+	if c == nil {
+		return contextResult{}
+	}
+
+	limit := c.Window - c.ReserveOutput
+	for _, field := range []string{"max_tokens", "max_completion_tokens"} {
+		if maxTokens := intValue(reqJSON[field]); maxTokens > 0 && maxTokens < c.Window && c.Window-maxTokens < limit {
+			limit = c.Window - maxTokens
+		}
+	}
+	total := estimatePromptTokens(reqJSON)
+	if total <= limit {
+		return contextResult{}
+	}
+
+	keepTurns := c.KeepTurns
+	if keepTurns == 0 {
+		keepTurns = 1
+	}
+	dropped := dropOldestTurns(reqJSON, limit, keepTurns)
+	if len(dropped) == 0 {
+		if h.logger != nil {
+			h.logger.Info("后端 %s 的输入超出上下文窗口（估算 %d / %d tokens），但没有可删除的对话", backend.Name, total, limit)
+		}
+		return contextResult{}
+	}
+	result := contextResult{dropped: len(dropped)}
+
+	// 摘要请求本身不再摘要，避免摘要后端也配置了summarize时递归调用
+	if c.Strategy == "summarize" && !isSummaryRequest(r) {
+		summary, err := h.summarizeMessages(r, c.SummaryBackend, dropped)
+		if err != nil {
+			if h.logger != nil {
+				h.logger.Error("后端 %s 生成对话摘要失败，改为直接删除: %v", c.SummaryBackend, err)
+			}
+		} else {
+			truncated, _ := reqJSON["messages"].([]interface{})
+			reqJSON["messages"] = insertSummary(truncated, summary)
+			// 插入摘要后再次检查，仍超出时继续删除较早的对话（这部分不再摘要）
+			more := dropOldestTurns(reqJSON, limit, keepTurns)
+			if estimatePromptTokens(reqJSON) <= limit {
+				result.dropped += len(more)
+				result.summarized = true
+			} else {
+				// 摘要本身过长，放弃摘要
+				if h.logger != nil {
+					h.logger.Info("后端 %s 的对话摘要过长，改为直接删除", backend.Name)
+				}
+				reqJSON["messages"] = truncated
+			}
+		}
+	}
+
+	if h.logger != nil {
+		h.logger.Info("后端 %s 的输入超出上下文窗口（限制 %d tokens），删除最早的 %d 条消息，剩余估算 %d tokens", backend.Name, limit, result.dropped, estimatePromptTokens(reqJSON))
+	}
+	return result
+}
+
+// dropOldestTurns 从最早的对话轮次开始删除消息，直到估算值不超过limit或只剩keepTurns轮，返回删除的消息
+func dropOldestTurns(reqJSON map[string]interface{}, limit, keepTurns int) []interface{} {
+	total := estimatePromptTokens(reqJSON)
+	messages, _ := reqJSON["messages"].([]interface{})
+	turns := splitTurns(messages)
+
+	dropTurns := 0
+	for dropTurns < len(turns)-keepTurns && total > limit {
+		for _, i := range turns[dropTurns] {
+			message, _ := messages[i].(map[string]interface{})
+			total -= estimateMessageTokens(message)
+		}
+		dropTurns++
+	}
+	if dropTurns == 0 {
+		return nil
+	}
+
+	droppedIndex := map[int]bool{}
+	var dropped []interface{}
+	for _, turn := range turns[:dropTurns] {
+		for _, i := range turn {
+			droppedIndex[i] = true
+			dropped = append(dropped, messages[i])
+		}
+	}
+	out := make([]interface{}, 0, len(messages)-len(dropped))
+	for i, raw := range messages {
+		if !droppedIndex[i] {
+			out = append(out, raw)
+		}
+	}
+	reqJSON["messages"] = out
+	return dropped
+}
+
+// insertSummary 将摘要作为系统消息插入到开头的系统消息之后
+func insertSummary(messages []interface{}, summary string) []interface{} {
+	out := make([]interface{}, 0, len(messages)+1)
+	inserted := false
+	for _, raw := range messages {
+		if !inserted && !isSystemMessage(raw) {
+			out = append(out, map[string]interface{}{"role": "system", "content": "以下是之前对话的摘要：\n" + summary})
+			inserted = true
+		}
+		out = append(out, raw)
+	}
+	if !inserted {
+		out = append(out, map[string]interface{}{"role": "system", "content": "以下是之前对话的摘要：\n" + summary})
+	}
+	return out
+}
+
+// splitTurns 将非系统消息按对话轮次分组，返回每轮消息的下标
+// 每个user消息开始新的一轮，assistant和tool消息归入当前轮，保证工具调用与结果不会被拆开
+func splitTurns(messages []interface{}) [][]int {
+	var turns [][]int
+	for i, raw := range messages {
+		if isSystemMessage(raw) {
+			continue
+		}
+		message, _ := raw.(map[string]interface{})
+		if message["role"] == "user" || len(turns) == 0 {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], i)
+	}
+	return turns
+}
+
+// summaryRequestKey 标记摘要请求的context键
+type summaryRequestKey struct{}
+
+// isSummaryRequest 判断请求是否为代理发起的摘要请求
+func isSummaryRequest(r *http.Request) bool {
+	return r.Context().Value(summaryRequestKey{}) != nil
+}
+
+// summarizeMessages 调用摘要后端把一组消息压缩为摘要
+// 摘要请求与普通请求一样经过熔断器、重试以及后端的参数预设和兼容性配置，用量同样写入账本
+func (h *Handler) summarizeMessages(r *http.Request, backendName string, messages []interface{}) (string, error) {
+	backend := h.router.backendByName(backendName)
+	if backend == nil {
+		return "", fmt.Errorf("摘要后端不存在: %s", backendName)
+	}
+
+	var transcript strings.Builder
+	for _, raw := range messages {
+		message, _ := raw.(map[string]interface{})
+		role, _ := message["role"].(string)
+		transcript.WriteString(role + ": " + anthropicText(message["content"]))
+		if toolCalls, ok := message["tool_calls"]; ok {
+			data, _ := json.Marshal(toolCalls)
+			transcript.WriteString("\n工具调用: " + string(data))
+		}
+		transcript.WriteString("\n\n")
+	}
+
+	sum := sha256.Sum256([]byte(backendName + "\x00" + transcript.String()))
+	key := hex.EncodeToString(sum[:])
+	if summary, ok := h.summaries.get(key); ok {
+		return summary, nil
+	}
+
+	if !h.breakers.allow(backend) {
+		return "", fmt.Errorf("摘要后端 %s 已熔断", backendName)
+	}
+	reqJSON := map[string]interface{}{
+		"model":  backend.CustomModelID,
+		"stream": false,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "system", "content": summaryPrompt},
+			map[string]interface{}{"role": "user", "content": transcript.String()},
+		},
+	}
+	summaryReq := r.WithContext(context.WithValue(r.Context(), summaryRequestKey{}, true))
+	upstream, err := h.sendWithRetry(summaryReq, backend, reqJSON)
+	if err != nil {
+		if pe, ok := err.(*proxyError); ok && pe.status != http.StatusServiceUnavailable {
+			h.breakers.record(backend, breakerIgnore)
+		} else {
+			h.breakers.record(backend, breakerFailure)
+		}
+		h.recordFailedUsage(summaryReq, backend, backend.CustomModelID, reqJSON, err)
+		return "", err
+	}
+	// 摘要调用同样消耗后端token，按摘要后端和模型记入用量
+	upstream.model = backend.CustomModelID
+	defer h.recordUsage(summaryReq, upstream, false)
+	defer upstream.resp.Body.Close()
+	if h.shouldFailover(upstream.resp.StatusCode) {
+		h.breakers.record(backend, breakerFailure)
+	} else {
+		h.breakers.record(backend, breakerSuccess)
+	}
+	if upstream.resp.StatusCode >= 400 {
+		errorBody, _ := io.ReadAll(upstream.resp.Body)
+		return "", fmt.Errorf("HTTP %d: %s", upstream.resp.StatusCode, normalizeUpstreamError(upstream.resp.StatusCode, errorBody).message)
+	}
+
+	respJSON, err := upstream.readJSON()
+	if err != nil {
+		return "", err
+	}
+	choices, _ := respJSON["choices"].([]interface{})
+	if len(choices) == 0 {
+		return "", fmt.Errorf("摘要响应为空")
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	summary := strings.TrimSpace(anthropicText(message["content"]))
+	if summary == "" {
+		return "", fmt.Errorf("摘要响应为空")
+	}
+	h.summaries.put(key, summary)
+	return summary, nil
+}
+
+// setContextHeaders 发生上下文裁剪时在响应头中说明
+func (u *upstreamResponse) setContextHeaders(w http.ResponseWriter) {
+	if u.contextFit.dropped == 0 {
+		return
+	}
+	w.Header().Set(headerContextTruncated, "true")
+	w.Header().Set(headerContextDropped, strconv.Itoa(u.contextFit.dropped))
+	if u.contextFit.summarized {
+		w.Header().Set(headerContextSummary, "true")
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"trae-proxy-go/pkg/models"
+)
+
+// turnMessage 构造一条约104 token的消息，name用于在测试中识别消息
+func turnMessage(role, name string) map[string]interface{} {
+	return map[string]interface{}{"role": role, "name": name, "content": strings.Repeat("字", 100)}
+}
+
+// conversation 构造系统消息加n轮user/assistant对话
+func conversation(n int) []interface{} {
+	messages := []interface{}{map[string]interface{}{"role": "system", "content": "系统"}}
+	for i := 1; i <= n; i++ {
+		messages = append(messages, turnMessage("user", fmt.Sprintf("u%d", i)), turnMessage("assistant", fmt.Sprintf("a%d", i)))
+	}
+	return messages
+}
+
+// toolTurn 构造一轮包含工具调用及其结果的对话
+func toolTurn(i int) []interface{} {
+	call := turnMessage("assistant", fmt.Sprintf("call%d", i))
+	call["content"] = ""
+	call["tool_calls"] = []interface{}{map[string]interface{}{
+		"id":       fmt.Sprintf("call_%d", i),
+		"type":     "function",
+		"function": map[string]interface{}{"name": "read", "arguments": "{}"},
+	}}
+	result := turnMessage("tool", fmt.Sprintf("tool%d", i))
+	result["tool_call_id"] = fmt.Sprintf("call_%d", i)
+	return []interface{}{turnMessage("user", fmt.Sprintf("u%d", i)), call, result, turnMessage("assistant", fmt.Sprintf("a%d", i))}
+}
+
+// messageLabels 返回消息的name，没有name时返回role
+func messageLabels(messages []interface{}) []string {
+	var labels []string
+	for _, raw := range messages {
+		message := raw.(map[string]interface{})
+		if name, ok := message["name"].(string); ok {
+			labels = append(labels, name)
+		} else {
+			labels = append(labels, message["role"].(string))
+		}
+	}
+	return labels
+}
+
+func TestFitContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     models.ContextConfig
+		messages    []interface{}
+		maxTokens   int
+		wantDropped int
+		wantLabels  []string
+	}{
+		{
+			name:       "未超出窗口不裁剪",
+			context:    models.ContextConfig{Window: 1000},
+			messages:   conversation(2),
+			wantLabels: []string{"system", "u1", "a1", "u2", "a2"},
+		},
+		{
+			name:        "删除最早的轮次并保留系统消息",
+			context:     models.ContextConfig{Window: 500},
+			messages:    conversation(4),
+			wantDropped: 4,
+			wantLabels:  []string{"system", "u3", "a3", "u4", "a4"},
+		},
+		{
+			name:        "为输出预留token",
+			context:     models.ContextConfig{Window: 800, ReserveOutput: 300},
+			messages:    conversation(4),
+			wantDropped: 4,
+			wantLabels:  []string{"system", "u3", "a3", "u4", "a4"},
+		},
+		{
+			name:        "请求的max_tokens更大时以请求为准",
+			context:     models.ContextConfig{Window: 1000},
+			messages:    conversation(2),
+			maxTokens:   700,
+			wantDropped: 2,
+			wantLabels:  []string{"system", "u2", "a2"},
+		},
+		{
+			name:        "默认保留最近一轮",
+			context:     models.ContextConfig{Window: 100},
+			messages:    conversation(4),
+			wantDropped: 6,
+			wantLabels:  []string{"system", "u4", "a4"},
+		},
+		{
+			name:        "keep_turns保留最近的轮次",
+			context:     models.ContextConfig{Window: 100, KeepTurns: 3},
+			messages:    conversation(4),
+			wantDropped: 2,
+			wantLabels:  []string{"system", "u2", "a2", "u3", "a3", "u4", "a4"},
+		},
+		{
+			name:        "只有keep_turns轮时不裁剪",
+			context:     models.ContextConfig{Window: 100, KeepTurns: 2},
+			messages:    conversation(2),
+			wantLabels:  []string{"system", "u1", "a1", "u2", "a2"},
+			wantDropped: 0,
+		},
+		{
+			name:        "工具调用和结果一起删除",
+			context:     models.ContextConfig{Window: 300},
+			messages:    append(append(conversation(0), toolTurn(1)...), turnMessage("user", "u2"), turnMessage("assistant", "a2")),
+			wantDropped: 4,
+			wantLabels:  []string{"system", "u2", "a2"},
+		},
+		{
+			name:        "工具调用和结果一起保留",
+			context:     models.ContextConfig{Window: 100},
+			messages:    append(conversation(1), toolTurn(2)...),
+			wantDropped: 2,
+			wantLabels:  []string{"system", "u2", "call2", "tool2", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{APIs: []models.API{{Name: "main", CustomModelID: "m", Active: true, Context: &tt.context}}}
+			h := NewHandler(cfg, nil)
+			reqJSON := map[string]interface{}{"model": "m", "messages": tt.messages}
+			if tt.maxTokens > 0 {
+				reqJSON["max_tokens"] = tt.maxTokens
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			result := h.fitContext(r, &cfg.APIs[0], reqJSON)
+			if result.dropped != tt.wantDropped || result.summarized {
+				t.Errorf("result = %+v, want dropped=%d", result, tt.wantDropped)
+			}
+			if got := messageLabels(reqJSON["messages"].([]interface{})); !reflect.DeepEqual(got, tt.wantLabels) {
+				t.Errorf("messages = %v, want %v", got, tt.wantLabels)
+			}
+		})
+	}
+}
+
+// summaryServer 返回固定摘要的后端，并统计收到的请求数
+func summaryServer(t *testing.T, status int, summary string, hits *int32) *httptest.Server {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]interface{}{"role": "assistant", "content": summary}}},
+		"usage":   map[string]interface{}{"prompt_tokens": 400, "completion_tokens": 10},
+	})
+	return statusServer(t, status, string(body), hits)
+}
+
+func TestFitContextSummarize(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		wantSummarized bool
+		wantLabels     []string
+	}{
+		{
+			name:           "摘要插入到系统消息之后",
+			status:         http.StatusOK,
+			wantSummarized: true,
+			wantLabels:     []string{"system", "system", "u3", "a3", "u4", "a4"},
+		},
+		{
+			name:       "摘要失败时直接删除",
+			status:     http.StatusInternalServerError,
+			wantLabels: []string{"system", "u3", "a3", "u4", "a4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := summaryServer(t, tt.status, "之前讨论了u1和u2", &hits)
+			cfg := &models.Config{APIs: []models.API{
+				{Name: "main", CustomModelID: "m", Active: true,
+					Context: &models.ContextConfig{Window: 500, Strategy: "summarize", SummaryBackend: "summary"}},
+				{Name: "summary", Endpoint: srv.URL, CustomModelID: "s", TargetModelID: "t", Active: true},
+			}}
+			h := NewHandler(cfg, nil)
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+			// 同样的对话第二次裁剪时使用缓存的摘要
+			for i := 0; i < 2; i++ {
+				reqJSON := map[string]interface{}{"model": "m", "messages": conversation(4)}
+				result := h.fitContext(r, &cfg.APIs[0], reqJSON)
+				if result.dropped != 4 || result.summarized != tt.wantSummarized {
+					t.Errorf("result = %+v", result)
+				}
+				messages := reqJSON["messages"].([]interface{})
+				if got := messageLabels(messages); !reflect.DeepEqual(got, tt.wantLabels) {
+					t.Fatalf("messages = %v, want %v", got, tt.wantLabels)
+				}
+				if tt.wantSummarized {
+					if content := messages[1].(map[string]interface{})["content"].(string); !strings.HasSuffix(content, "之前讨论了u1和u2") {
+						t.Errorf("摘要消息 = %q", content)
+					}
+				}
+			}
+			wantHits := int32(1)
+			if !tt.wantSummarized {
+				wantHits = 2
+			}
+			if hits != wantHits {
+				t.Errorf("摘要后端收到%d次请求, want %d", hits, wantHits)
+			}
+		})
+	}
+}
+
+func TestContextHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		turns       int
+		wantHeaders map[string]string
+		wantSent    int // 后端收到的消息数
+	}{
+		{
+			name:        "裁剪后写入响应头",
+			turns:       4,
+			wantHeaders: map[string]string{headerContextTruncated: "true", headerContextDropped: "4", headerContextSummary: ""},
+			wantSent:    5,
+		},
+		{
+			name:        "未裁剪时没有响应头",
+			turns:       1,
+			wantHeaders: map[string]string{headerContextTruncated: "", headerContextDropped: "", headerContextSummary: ""},
+			wantSent:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&req)
+				sent = len(req["messages"].([]interface{}))
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"choices":[]}`)
+			}))
+			defer srv.Close()
+			cfg := &models.Config{APIs: []models.API{{Name: "main", Endpoint: srv.URL, CustomModelID: "m", TargetModelID: "t", Active: true,
+				Context: &models.ContextConfig{Window: 500}}}}
+			h := NewHandler(cfg, nil)
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			upstream, err := h.forwardChatCompletion(r, map[string]interface{}{"model": "m", "messages": conversation(tt.turns)})
+			if err != nil {
+				t.Fatalf("forwardChatCompletion: %v", err)
+			}
+			upstream.resp.Body.Close()
+			if sent != tt.wantSent {
+				t.Errorf("后端收到%d条消息, want %d", sent, tt.wantSent)
+			}
+
+			w := httptest.NewRecorder()
+			upstream.setContextHeaders(w)
+			for name, want := range tt.wantHeaders {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestContextHeadersSummarized(t *testing.T) {
+	w := httptest.NewRecorder()
+	upstream := &upstreamResponse{contextFit: contextResult{dropped: 6, summarized: true}}
+	upstream.setContextHeaders(w)
+	if w.Header().Get(headerContextSummary) != "true" || w.Header().Get(headerContextDropped) != "6" {
+		t.Errorf("响应头 = %v", w.Header())
+	}
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrorBodyLength 非JSON错误响应体作为错误信息时保留的最大字符数
+const maxErrorBodyLength = 500
+
+// apiError OpenAI格式的错误: {"error": {"message", "type", "param", "code"}}
+type apiError struct {
+	status  int
+	message string
+	errType string
+	param   string
+	code    string
+}
+
+// envelope 返回OpenAI错误格式的响应体，param和code为空时输出null
+func (e *apiError) envelope() map[string]interface{} {
+	errType := e.errType
+	if errType == "" {
+		errType = errorTypeForStatus(e.status)
+	}
+	var param, code interface{}
+	if e.param != "" {
+		param = e.param
+	}
+	if e.code != "" {
+		code = e.code
+	}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": e.message,
+			"type":    errType,
+			"param":   param,
+			"code":    code,
+		},
+	}
+}
+
+// errorTypeForStatus 按状态码返回OpenAI的错误类型
+func errorTypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
+
+// writeAPIError 以OpenAI错误格式写入错误响应
+func (h *Handler) writeAPIError(w http.ResponseWriter, e *apiError) {
+	h.writeJSON(w, e.envelope(), e.status)
+}
+
+// providerErrorRule 服务商错误到OpenAI错误的映射规则
+type providerErrorRule struct {
+	status   int
+	errType  string
+	code     string
+	param    string
+	statuses []int    // 命中的后端状态码
+	codes    []string // 命中的后端type或code（小写）
+	messages []string // 命中的错误信息片段（小写）
+}
+
+// providerErrorRules DeepSeek、Moonshot、DashScope等服务商的常见错误，按顺序匹配
+var providerErrorRules = []providerErrorRule{
+	{
+		// DeepSeek: 402 Insufficient Balance；Moonshot: exceeded_current_quota_error；DashScope: Arrearage
+		status: http.StatusTooManyRequests, errType: "insufficient_quota", code: "insufficient_quota",
+		statuses: []int{http.StatusPaymentRequired},
+		codes:    []string{"insufficient_quota", "insufficient_balance", "exceeded_current_quota_error", "arrearage"},
+		messages: []string{"insufficient balance", "insufficient_balance", "余额不足", "欠费"},
+	},
+	{
+		// DeepSeek: Content Exists Risk；Moonshot: content_filter；DashScope: DataInspectionFailed
+		status: http.StatusBadRequest, errType: "invalid_request_error", code: "content_policy_violation",
+		codes:    []string{"content_filter", "content_policy_violation", "datainspectionfailed", "data_inspection_failed"},
+		messages: []string{"content exists risk", "considered high risk", "inappropriate content", "内容安全", "敏感内容"},
+	},
+	{
+		// DeepSeek: maximum context length；Moonshot: exceeded model token limit；DashScope: Range of input length
+		status: http.StatusBadRequest, errType: "invalid_request_error", code: "context_length_exceeded", param: "messages",
+		codes:    []string{"context_length_exceeded"},
+		messages: []string{"maximum context length", "context length", "context_length", "exceeded model token limit", "range of input length", "input length should be", "上下文长度"},
+	},
+	{
+		status: http.StatusUnauthorized, errType: "invalid_request_error", code: "invalid_api_key",
+		statuses: []int{http.StatusUnauthorized},
+		codes:    []string{"invalid_api_key", "invalidapikey", "invalid_authentication_error", "authentication_error"},
+	},
+	{
+		// Moonshot: rate_limit_reached_error；DashScope: Throttling
+		status: http.StatusTooManyRequests, errType: "rate_limit_error", code: "rate_limit_exceeded",
+		statuses: []int{http.StatusTooManyRequests},
+		codes:    []string{"rate_limit_exceeded", "rate_limit_reached_error", "throttling", "throttling.ratequota", "throttling.allocationquota"},
+	},
+}
+
+// matches 判断后端错误是否命中规则
+func (rule *providerErrorRule) matches(status int, codes []string, message string) bool {
+	for _, s := range rule.statuses {
+		if s == status {
+			return true
+		}
+	}
+	for _, c := range rule.codes {
+		for _, code := range codes {
+			if c == code {
+				return true
+			}
+		}
+	}
+	for _, m := range rule.messages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeUpstreamError 将后端错误响应转换为OpenAI格式的错误
+// 兼容 {"error": {...}}、{"error": "..."} 以及DashScope的 {"code", "message"}，非JSON响应体作为错误信息
+func normalizeUpstreamError(status int, body []byte) *apiError {
+	e := &apiError{status: status}
+
+	var errorJSON map[string]interface{}
+	if err := json.Unmarshal(body, &errorJSON); err == nil {
+		fields := errorJSON
+		switch inner := errorJSON["error"].(type) {
+		case map[string]interface{}:
+			fields = inner
+		case string:
+			e.message = inner
+		}
+		if e.message == "" {
+			e.message, _ = fields["message"].(string)
+		}
+		e.errType, _ = fields["type"].(string)
+		e.param, _ = fields["param"].(string)
+		e.code = stringValue(fields["code"])
+	} else if text := strings.TrimSpace(string(body)); text != "" {
+		e.message = truncateRunes(text, maxErrorBodyLength)
+	}
+	if e.message == "" {
+		e.message = fmt.Sprintf("HTTP错误: %d %s", status, http.StatusText(status))
+	}
+
+	codes := []string{strings.ToLower(e.errType), strings.ToLower(e.code)}
+	message := strings.ToLower(e.message)
+	for i := range providerErrorRules {
+		rule := &providerErrorRules[i]
+		if rule.matches(status, codes, message) {
+			e.status = rule.status
+			e.errType = rule.errType
+			e.code = rule.code
+			if rule.param != "" {
+				e.param = rule.param
+			}
+			return e
+		}
+	}
+
+	// 未识别的错误保留后端的code，type按状态码归一
+	if e.status < 400 {
+		e.status = http.StatusBadGateway
+	}
+	e.errType = errorTypeForStatus(e.status)
+	return e
+}
+
+// stringValue 将code等字段转换为字符串，部分服务商的code为数字
+func stringValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return fmt.Sprintf("%v", value)
+	}
+	return ""
+}
+
+// truncateRunes 按字符截断字符串
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trae-proxy-go/pkg/models"
+)
+
+func TestNormalizeUpstreamError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantStatus  int
+		wantType    string
+		wantCode    string
+		wantParam   string
+		wantMessage string
+	}{
+		{
+			name:        "401无效的API Key",
+			status:      http.StatusUnauthorized,
+			body:        `{"error":{"message":"Authentication Fails (no such user)","type":"authentication_error","code":"invalid_request_error"}}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantType:    "invalid_request_error",
+			wantCode:    "invalid_api_key",
+			wantMessage: "Authentication Fails (no such user)",
+		},
+		{
+			name:        "404模型不存在保留后端的code",
+			status:      http.StatusNotFound,
+			body:        `{"error":{"message":"The model does not exist","type":"invalid_request_error","code":"model_not_found","param":"model"}}`,
+			wantStatus:  http.StatusNotFound,
+			wantType:    "invalid_request_error",
+			wantCode:    "model_not_found",
+			wantParam:   "model",
+			wantMessage: "The model does not exist",
+		},
+		{
+			name:        "429限流",
+			status:      http.StatusTooManyRequests,
+			body:        `{"error":{"message":"Rate limit reached","type":"rate_limit_reached_error"}}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantType:    "rate_limit_error",
+			wantCode:    "rate_limit_exceeded",
+			wantMessage: "Rate limit reached",
+		},
+		{
+			name:        "DashScope限流",
+			status:      http.StatusBadRequest,
+			body:        `{"code":"Throttling.RateQuota","message":"Requests rate limit exceeded"}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantType:    "rate_limit_error",
+			wantCode:    "rate_limit_exceeded",
+			wantMessage: "Requests rate limit exceeded",
+		},
+		{
+			name:        "402余额不足转换为429 insufficient_quota",
+			status:      http.StatusPaymentRequired,
+			body:        `{"error":{"message":"Insufficient Balance","type":"unknown_error"}}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantType:    "insufficient_quota",
+			wantCode:    "insufficient_quota",
+			wantMessage: "Insufficient Balance",
+		},
+		{
+			name:        "上下文超长",
+			status:      http.StatusBadRequest,
+			body:        `{"error":{"message":"This model's maximum context length is 65536 tokens","type":"invalid_request_error"}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantType:    "invalid_request_error",
+			wantCode:    "context_length_exceeded",
+			wantParam:   "messages",
+			wantMessage: "This model's maximum context length is 65536 tokens",
+		},
+		{
+			name:        "500服务端错误",
+			status:      http.StatusInternalServerError,
+			body:        `{"error":{"message":"internal error","type":"api_error","code":10001}}`,
+			wantStatus:  http.StatusInternalServerError,
+			wantType:    "server_error",
+			wantCode:    "10001",
+			wantMessage: "internal error",
+		},
+		{
+			name:        "503错误字段为字符串",
+			status:      http.StatusServiceUnavailable,
+			body:        `{"error":"Service Unavailable"}`,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantType:    "server_error",
+			wantMessage: "Service Unavailable",
+		},
+		{
+			name:        "非JSON响应体作为错误信息",
+			status:      http.StatusBadGateway,
+			body:        "<html>502 Bad Gateway</html>\n",
+			wantStatus:  http.StatusBadGateway,
+			wantType:    "server_error",
+			wantMessage: "<html>502 Bad Gateway</html>",
+		},
+		{
+			name:        "空响应体使用状态码描述",
+			status:      http.StatusGatewayTimeout,
+			wantStatus:  http.StatusGatewayTimeout,
+			wantType:    "server_error",
+			wantMessage: "HTTP错误: 504 Gateway Timeout",
+		},
+		{
+			name:        "非错误状态码按502返回",
+			status:      http.StatusOK,
+			body:        "not json",
+			wantStatus:  http.StatusBadGateway,
+			wantType:    "server_error",
+			wantMessage: "not json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := normalizeUpstreamError(tt.status, []byte(tt.body))
+			if e.status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", e.status, tt.wantStatus)
+			}
+			fields := e.envelope()["error"].(map[string]interface{})
+			if fields["type"] != tt.wantType {
+				t.Errorf("type = %v, want %s", fields["type"], tt.wantType)
+			}
+			if fields["message"] != tt.wantMessage {
+				t.Errorf("message = %v, want %s", fields["message"], tt.wantMessage)
+			}
+			checkNullable(t, "code", fields["code"], tt.wantCode)
+			checkNullable(t, "param", fields["param"], tt.wantParam)
+		})
+	}
+}
+
+// checkNullable 检查错误中的可选字段，期望值为空时应输出null
+func checkNullable(t *testing.T, field string, got interface{}, want string) {
+	t.Helper()
+	if want == "" {
+		if got != nil {
+			t.Errorf("%s = %v, want null", field, got)
+		}
+		return
+	}
+	if got != want {
+		t.Errorf("%s = %v, want %s", field, got, want)
+	}
+}
+
+func TestNormalizeUpstreamErrorTruncatesBody(t *testing.T) {
+	body := strings.Repeat("错", maxErrorBodyLength+10)
+	e := normalizeUpstreamError(http.StatusBadGateway, []byte(body))
+	if want := strings.Repeat("错", maxErrorBodyLength) + "..."; e.message != want {
+		t.Errorf("message长度 = %d, want %d", len([]rune(e.message)), len([]rune(want)))
+	}
+}
+
+func TestWriteAPIError(t *testing.T) {
+	h := NewHandler(&models.Config{}, nil)
+	w := httptest.NewRecorder()
+	h.writeAPIError(w, normalizeUpstreamError(http.StatusTooManyRequests, []byte(`{"error":{"message":"slow down"}}`)))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+	var body struct {
+		Error map[string]interface{} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("响应不是JSON: %v", err)
+	}
+	for _, field := range []string{"message", "type", "param", "code"} {
+		if _, ok := body.Error[field]; !ok {
+			t.Errorf("error中缺少%s字段: %s", field, w.Body.String())
+		}
+	}
+	if body.Error["type"] != "rate_limit_error" || body.Error["code"] != "rate_limit_exceeded" {
+		t.Errorf("error = %v", body.Error)
+	}
+}
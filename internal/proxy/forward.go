@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+// defaultFailoverStatusCodes 未配置failover.status_codes时切换到下一个后端的状态码
+var defaultFailoverStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// proxyError 代理自身产生的错误，携带返回给客户端的状态码
+type proxyError struct {
+	status  int
+	message string
+	code    string // OpenAI错误码，如 model_not_found
+	param   string
+}
+
+func (e *proxyError) Error() string { return e.message }
+
+// upstreamResponse 后端返回的响应
+type upstreamResponse struct {
+	ctx     context.Context
+	backend *models.API
+	resp    *http.Response
+	stream  bool   // 转发给后端的请求是否为流式
+	model   string // 返回给客户端的模型ID
+	// toolEmulation 工具定义已写入提示词，需要从响应文本中解析工具调用
+	toolEmulation bool
+	contextFit    contextResult // 上下文裁剪结果
+	usage         *usageTracker
+}
+
+// forwardChatCompletion 根据请求的模型选择后端，改写模型ID后转发chat/completions请求
+// 多个后端共用同一个自定义模型ID时按优先级依次尝试，连接失败或返回可重试的状态码时切换到下一个后端；
+// 响应开始返回给客户端之后不再切换
+func (h *Handler) forwardChatCompletion(r *http.Request, reqJSON map[string]interface{}) (*upstreamResponse, error) {
+	// 选择候选后端
+	candidates, clientModel := h.router.selectCandidates(r.Header, reqJSON)
+	if len(candidates) == 0 {
+		if h.config.Strict {
+			requestedModel, _ := reqJSON["model"].(string)
+			return nil, &proxyError{
+				status:  http.StatusNotFound,
+				message: fmt.Sprintf("模型 `%s` 不存在，可用模型: %s", requestedModel, strings.Join(h.router.modelIDs(), ", ")),
+				code:    "model_not_found",
+				param:   "model",
+			}
+		}
+		if name := h.config.DefaultBackend; name != "" {
+			if h.logger != nil {
+				h.logger.Error("default_backend %s 所在的模型组中没有激活的后端", name)
+			}
+			return nil, &proxyError{
+				status:  http.StatusServiceUnavailable,
+				message: fmt.Sprintf("默认后端 `%s` 所在的模型组中没有激活的后端", name),
+			}
+		}
+		return nil, &proxyError{status: http.StatusServiceUnavailable, message: "未找到可用的后端API配置（没有激活的后端）"}
+	}
+	candidates = h.balancer.order(candidates[0].CustomModelID, candidates)
+
+	var lastErr error
+	var lastBackend *models.API // 最后一个实际发送过请求的后端
+	// lastFailed 切换前后端返回的错误响应，之后的候选都没有实际发送请求（如均已熔断）时原样返回给客户端
+	var lastFailed *upstreamResponse
+	for i, backend := range candidates {
+		hasNext := i < len(candidates)-1
+
+		// 熔断中的后端不参与本次请求
+		if !h.breakers.allow(backend) {
+			if h.logger != nil {
+				h.logger.Info("后端 %s 已熔断，跳过", backend.Name)
+			}
+			if lastErr == nil {
+				lastErr = &proxyError{status: http.StatusServiceUnavailable, message: "所有候选后端均已熔断"}
+			}
+			continue
+		}
+
+		// 每次尝试使用独立的请求体，避免上一个后端的改写影响下一个
+		upstream, err := h.sendWithRetry(r, backend, reqJSON)
+		lastBackend = backend
+		lastFailed = nil
+
+		if err != nil {
+			lastErr = err
+			if pe, ok := err.(*proxyError); ok && pe.status != http.StatusServiceUnavailable {
+				// 请求构造类错误或客户端已断开，换后端也无法解决
+				h.breakers.record(backend, breakerIgnore)
+				return nil, err
+			}
+			h.breakers.record(backend, breakerFailure)
+			if hasNext && h.logger != nil {
+				h.logger.Info("后端 %s 请求失败，切换到下一个后端: %v", backend.Name, err)
+			}
+			continue
+		}
+
+		failed := h.shouldFailover(upstream.resp.StatusCode)
+		if failed {
+			h.breakers.record(backend, breakerFailure)
+		} else {
+			h.breakers.record(backend, breakerSuccess)
+		}
+
+		if hasNext && failed {
+			if h.logger != nil {
+				h.logger.Info("后端 %s 返回 %s，切换到下一个后端", backend.Name, upstream.resp.Status)
+			}
+			// 错误响应体读入内存并释放连接，后面的候选都无法尝试时仍能返回真实的状态码和错误信息
+			body, _ := io.ReadAll(upstream.resp.Body)
+			upstream.resp.Body.Close()
+			upstream.resp.Body = io.NopCloser(bytes.NewReader(body))
+			lastFailed = upstream
+			continue
+		}
+
+		if h.logger != nil {
+			h.logger.Info("请求由后端 %s 处理（第%d/%d个候选）", backend.Name, i+1, len(candidates))
+		}
+		upstream.model = clientModel
+		return upstream, nil
+	}
+	if lastFailed != nil {
+		if h.logger != nil {
+			h.logger.Info("后续候选后端均未尝试，返回后端 %s 的响应 %s", lastFailed.backend.Name, lastFailed.resp.Status)
+		}
+		lastFailed.model = clientModel
+		return lastFailed, nil
+	}
+	if lastBackend != nil {
+		h.recordFailedUsage(r, lastBackend, clientModel, reqJSON, lastErr)
+	}
+	return nil, lastErr
+}
+
+// shouldFailover 判断状态码是否需要切换到下一个后端
+func (h *Handler) shouldFailover(statusCode int) bool {
+	codes := defaultFailoverStatusCodes
+	if len(h.config.Failover.StatusCodes) > 0 {
+		codes = h.config.Failover.StatusCodes
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// sendToBackend 向指定后端发送一次chat/completions请求
+func (h *Handler) sendToBackend(r *http.Request, backend *models.API, reqJSON map[string]interface{}) (*upstreamResponse, error) {
+	targetAPIURL := backend.Endpoint
+	if h.logger != nil {
+		h.logger.Info("选择后端: %s -> %s", backend.Name, targetAPIURL)
+	}
+
+	// 修改模型ID，应用后端的参数预设和系统提示词
+	requestedModel, _ := reqJSON["model"].(string)
+	reqJSON["model"] = backend.TargetModelID
+	applyParamPreset(reqJSON, backend.Params)
+	applySystemPrompts(reqJSON, backend, requestedModel)
+
+	// 处理后端流模式
+	// streamMode: "true" 强制开启, "false"/"simulate" 强制关闭, "" 或不设置则跟随客户端请求
+	// 返回给客户端的格式由client_stream_mode决定，两者不一致时由代理合并或模拟
+	switch backend.StreamMode {
+	case "true":
+		reqJSON["stream"] = true
+	case "false", "simulate":
+		reqJSON["stream"] = false
+	}
+
+	// 后端不支持原生工具调用时改为提示词模拟
+	toolEmulation := false
+	if backend.ToolEmulation {
+		toolEmulation = emulateTools(reqJSON)
+	}
+
+	// 输入超出上下文窗口时裁剪较早的对话
+	contextFit := h.fitContext(r, backend, reqJSON)
+
+	// 非流式请求不能携带stream_options，部分后端会直接拒绝；流式请求注入include_usage以获取用量
+	if stream, _ := reqJSON["stream"].(bool); !stream {
+		delete(reqJSON, "stream_options")
+	}
+	usage := injectStreamUsage(reqJSON, backend)
+
+	// 按兼容性配置调整请求
+	if backend.Compat != "" {
+		profile, _ := config.LookupCompatProfile(h.config, backend.Compat)
+		applyCompatProfile(reqJSON, profile)
+	}
+
+	// 准备转发请求
+	usage.promptEstimate = estimatePromptTokens(reqJSON)
+	reqBody, err := json.Marshal(reqJSON)
+	if err != nil {
+		return nil, &proxyError{status: http.StatusInternalServerError, message: fmt.Sprintf("序列化请求失败: %v", err)}
+	}
+
+	targetURL := fmt.Sprintf("%s/v1/chat/completions", targetAPIURL)
+	if h.logger != nil {
+		h.logger.Debug("转发请求到: %s", targetURL)
+	}
+
+	// 创建转发请求
+	// 转发请求绑定客户端请求的context，客户端断开时立即中止
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, targetURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, &proxyError{status: http.StatusInternalServerError, message: fmt.Sprintf("创建请求失败: %v", err)}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if err := setBackendAuth(req, r, backend); err != nil {
+		if h.logger != nil {
+			h.logger.Error("后端 %s 密钥解析失败: %v", backend.Name, err)
+		}
+		return nil, &proxyError{status: http.StatusInternalServerError, message: fmt.Sprintf("后端密钥解析失败: %v", err)}
+	}
+
+	client, err := h.clients.get(backend)
+	if err != nil {
+		return nil, &proxyError{status: http.StatusInternalServerError, message: err.Error()}
+	}
+
+	// 发送请求，响应体关闭前计入后端的并发数
+	release := h.balancer.acquire(backend)
+	resp, err := client.Do(req)
+	if err != nil {
+		release()
+		if r.Context().Err() != nil {
+			return nil, &proxyError{status: statusClientClosedRequest, message: "客户端已断开连接"}
+		}
+		if h.logger != nil {
+			h.logger.Error("请求失败: %v", err)
+		}
+		return nil, &proxyError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("请求异常: %v", err)}
+	}
+	if backend.Transport != nil {
+		resp.Body = withIdleTimeout(resp.Body, backend.Transport.IdleStreamTimeout)
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+
+	// 以后端实际返回的Content-Type为准，部分后端会忽略stream参数
+	isStream, _ := reqJSON["stream"].(bool)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && resp.StatusCode < 400 {
+		isStream = strings.HasPrefix(contentType, "text/event-stream")
+	}
+	return &upstreamResponse{
+		ctx:           r.Context(),
+		backend:       backend,
+		resp:          resp,
+		stream:        isStream,
+		toolEmulation: toolEmulation,
+		contextFit:    contextFit,
+		usage:         usage,
+	}, nil
+}
+
+// setBackendAuth 设置转发请求的认证头
+// 后端配置了 api_key 时丢弃客户端的 Authorization 并注入后端密钥，否则沿用客户端的认证头
+func setBackendAuth(req *http.Request, clientReq *http.Request, backend *models.API) error {
+	if backend.APIKey == "" {
+		if authHeader := clientReq.Header.Get("Authorization"); authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		} else if apiKey := clientReq.Header.Get("x-api-key"); apiKey != "" {
+			// Anthropic 风格客户端通过 x-api-key 传递密钥
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		return nil
+	}
+
+	key, err := config.ResolveAPIKey(backend.APIKey)
+	if err != nil {
+		return err
+	}
+	req.Header.Del("Authorization")
+	req.Header.Set("Authorization", "Bearer "+key)
+	return nil
+}
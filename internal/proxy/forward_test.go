@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+// chatRequest 构造一个最简单的chat/completions请求体
+func chatRequest(model string) map[string]interface{} {
+	return map[string]interface{}{
+		"model":    model,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+	}
+}
+
+// statusServer 返回固定状态码和响应体的后端，并统计收到的请求数
+func statusServer(t *testing.T, status int, body string, hits *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestForwardFailover(t *testing.T) {
+	const overloaded = `{"error":{"message":"overloaded","type":"server_error"}}`
+	tests := []struct {
+		name        string
+		backupOpen  bool // 备用后端已熔断
+		backupCode  int
+		wantStatus  int
+		wantBackend string
+		wantBody    string
+		wantBackup  int32
+	}{
+		{
+			name:        "切换到备用后端",
+			backupCode:  http.StatusOK,
+			wantStatus:  http.StatusOK,
+			wantBackend: "backup",
+			wantBody:    `{"choices":[]}`,
+			wantBackup:  1,
+		},
+		{
+			name:        "备用后端已熔断时返回主后端的真实响应",
+			backupOpen:  true,
+			backupCode:  http.StatusOK,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantBackend: "primary",
+			wantBody:    overloaded,
+		},
+		{
+			name:        "备用后端也失败时返回备用后端的响应",
+			backupCode:  http.StatusBadGateway,
+			wantStatus:  http.StatusBadGateway,
+			wantBackend: "backup",
+			wantBody:    `{"choices":[]}`,
+			wantBackup:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var primaryHits, backupHits int32
+			primary := statusServer(t, http.StatusServiceUnavailable, overloaded, &primaryHits)
+			backup := statusServer(t, tt.backupCode, `{"choices":[]}`, &backupHits)
+			cfg := &models.Config{
+				APIs: []models.API{
+					{Name: "primary", Endpoint: primary.URL, CustomModelID: "m", TargetModelID: "t", Active: true, Priority: 1},
+					{Name: "backup", Endpoint: backup.URL, CustomModelID: "m", TargetModelID: "t", Active: true, Priority: 2,
+						CircuitBreaker: &models.CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Minute}},
+				},
+			}
+			h := NewHandler(cfg, nil)
+			if tt.backupOpen {
+				h.breakers.record(&cfg.APIs[1], breakerFailure)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			upstream, err := h.forwardChatCompletion(r, chatRequest("m"))
+			if err != nil {
+				t.Fatalf("forwardChatCompletion: %v", err)
+			}
+			defer upstream.resp.Body.Close()
+			body, _ := io.ReadAll(upstream.resp.Body)
+
+			if upstream.resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", upstream.resp.StatusCode, tt.wantStatus)
+			}
+			if upstream.backend.Name != tt.wantBackend {
+				t.Errorf("backend = %s, want %s", upstream.backend.Name, tt.wantBackend)
+			}
+			if strings.TrimSpace(string(body)) != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if upstream.model != "m" {
+				t.Errorf("model = %q, want m", upstream.model)
+			}
+			if primaryHits != 1 || backupHits != tt.wantBackup {
+				t.Errorf("请求次数 primary=%d backup=%d, want 1 %d", primaryHits, backupHits, tt.wantBackup)
+			}
+		})
+	}
+}
@@ -1,33 +1,47 @@
 package proxy
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"trae-proxy-go/internal/config"
 	"trae-proxy-go/internal/logger"
 	"trae-proxy-go/pkg/models"
 )
 
 // Handler 处理器结构
 type Handler struct {
-	config *models.Config
-	logger *logger.Logger
+	config    *models.Config
+	logger    *logger.Logger
+	responses *responseStore
+	balancer  *balancer
+	breakers  *breakerSet
+	clients   *backendClients
+	stats     requestStats
+	router    *router
+	summaries *summaryCache
+	ledger    *config.UsageLedger // 用量账本，为nil时不记录
 }
 
 // NewHandler 创建新的处理器
 func NewHandler(config *models.Config, logger *logger.Logger) *Handler {
 	return &Handler{
-		config: config,
-		logger: logger,
+		config:    config,
+		logger:    logger,
+		responses: newResponseStore(),
+		balancer:  newBalancer(config.LoadBalancing),
+		breakers:  newBreakerSet(config, logger),
+		clients:   newBackendClients(),
+		router:    newRouter(config),
+		summaries: newSummaryCache(),
 	}
 }
 
 // HandleRoot 处理根路径
 func (h *Handler) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -40,7 +54,7 @@ func (h *Handler) HandleRoot(w http.ResponseWriter, r *http.Request) {
 // HandleV1Root 处理/v1路径
 func (h *Handler) HandleV1Root(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -48,6 +62,8 @@ func (h *Handler) HandleV1Root(w http.ResponseWriter, r *http.Request) {
 		"message": "OpenAI API v1 endpoint",
 		"endpoints": map[string]string{
 			"chat/completions": "/v1/chat/completions",
+			"messages":         "/v1/messages",
+			"responses":        "/v1/responses",
 		},
 	}
 	h.writeJSON(w, response)
@@ -56,20 +72,19 @@ func (h *Handler) HandleV1Root(w http.ResponseWriter, r *http.Request) {
 // HandleModels 处理模型列表请求
 func (h *Handler) HandleModels(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// 路由规则中的别名也作为独立的模型列出
 	models := []map[string]interface{}{}
-	for _, api := range h.config.APIs {
-		if api.Active {
-			models = append(models, map[string]interface{}{
-				"id":       api.CustomModelID,
-				"object":   "model",
-				"created":  1,
-				"owned_by": "trae-proxy",
-			})
-		}
+	for _, id := range h.router.modelIDs() {
+		models = append(models, map[string]interface{}{
+			"id":       id,
+			"object":   "model",
+			"created":  1,
+			"owned_by": "trae-proxy",
+		})
 	}
 
 	response := map[string]interface{}{
@@ -82,129 +97,60 @@ func (h *Handler) HandleModels(w http.ResponseWriter, r *http.Request) {
 // HandleChatCompletions 处理聊天完成请求
 func (h *Handler) HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 检查Content-Type
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		h.writeError(w, "Content-Type必须为application/json", http.StatusBadRequest)
-		return
-	}
-
-	// 解析请求体
-	var reqJSON map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&reqJSON); err != nil {
-		h.writeError(w, fmt.Sprintf("无效的JSON请求体: %v", err), http.StatusBadRequest)
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 调试日志
-	if h.logger != nil {
-		h.logger.Debug("请求头: %v", r.Header)
-		reqJSONBytes, _ := json.Marshal(reqJSON)
-		h.logger.Debug("请求体: %s", string(reqJSONBytes))
-	}
-
-	// 获取请求的模型ID
-	requestedModel, _ := reqJSON["model"].(string)
-
-	// 选择后端API
-	selectedBackend := selectBackendByModel(h.config, requestedModel)
-	if selectedBackend == nil {
-		h.writeError(w, "未找到可用的后端API配置", http.StatusInternalServerError)
-		return
-	}
-
-	targetAPIURL := selectedBackend.Endpoint
-	targetModelID := selectedBackend.TargetModelID
-	customModelID := selectedBackend.CustomModelID
-	streamMode := selectedBackend.StreamMode
-
-	if h.logger != nil {
-		h.logger.Info("选择后端: %s -> %s", selectedBackend.Name, targetAPIURL)
-	}
-
-	// 修改模型ID
-	reqJSON["model"] = targetModelID
-
-	// 处理流模式
-	// streamMode: "true" 强制开启, "false" 强制关闭, "" 或不设置则保持原请求设置
-	if streamMode == "true" {
-		reqJSON["stream"] = true
-	} else if streamMode == "false" {
-		reqJSON["stream"] = false
-	}
-	// 如果streamMode为空，保持原请求的stream设置（不修改）
-
-	// 准备转发请求
-	reqBody, err := json.Marshal(reqJSON)
+	reqJSON, err := h.decodeJSONBody(r)
 	if err != nil {
-		h.writeError(w, fmt.Sprintf("序列化请求失败: %v", err), http.StatusInternalServerError)
+		h.writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	targetURL := fmt.Sprintf("%s/v1/chat/completions", targetAPIURL)
-	if h.logger != nil {
-		h.logger.Debug("转发请求到: %s", targetURL)
-	}
+	requestedStream, _ := reqJSON["stream"].(bool)
 
-	// 创建转发请求
-	req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewBuffer(reqBody))
+	upstream, err := h.forwardChatCompletion(r, reqJSON)
 	if err != nil {
-		h.writeError(w, fmt.Sprintf("创建请求失败: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
-		req.Header.Set("Authorization", authHeader)
-	}
-
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		if h.logger != nil {
-			h.logger.Error("请求失败: %v", err)
-		}
-		h.writeError(w, fmt.Sprintf("请求异常: %v", err), http.StatusServiceUnavailable)
+		h.writeProxyError(w, err)
 		return
 	}
+	resp := upstream.resp
 	defer resp.Body.Close()
+	customModelID := upstream.model
+	upstream.setContextHeaders(w)
+	clientStream := upstream.clientStream(requestedStream)
+	defer h.recordUsage(r, upstream, clientStream)
 
-	// 处理错误响应
+	// 处理错误响应，统一转换为OpenAI错误格式
 	if resp.StatusCode >= 400 {
 		errorBody, _ := io.ReadAll(resp.Body)
-		var errorJSON map[string]interface{}
-		if err := json.Unmarshal(errorBody, &errorJSON); err == nil {
-			h.writeJSON(w, errorJSON, resp.StatusCode)
-		} else {
-			h.writeError(w, fmt.Sprintf("HTTP错误: %s", resp.Status), resp.StatusCode)
-		}
+		h.writeAPIError(w, normalizeUpstreamError(resp.StatusCode, errorBody))
 		return
 	}
-
-	// 检查是否为流式响应
-	isStream, _ := reqJSON["stream"].(bool)
-	if isStream {
+	if clientStream && upstream.stream {
 		// 流式响应
 		if h.logger != nil {
 			h.logger.Debug("返回流式响应")
 		}
-		if err := StreamResponse(w, resp.Body, customModelID); err != nil {
-			if h.logger != nil {
+		if upstream.rewritesChunks() {
+			// 需要改写chunk时逐个解析后重新输出
+			err = h.rewriteStream(w, upstream, customModelID)
+		} else {
+			err = StreamResponse(r.Context(), w, &usageTapReader{r: resp.Body, tracker: upstream.usage}, customModelID)
+		}
+		if err != nil {
+			// 客户端断开由trackRequest统一记录
+			if h.logger != nil && r.Context().Err() == nil {
 				h.logger.Error("流式响应处理失败: %v", err)
 			}
 		}
 		return
 	}
 
-	// 非流式响应
-	var responseJSON map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&responseJSON); err != nil {
-		h.writeError(w, fmt.Sprintf("解析响应失败: %v", err), http.StatusInternalServerError)
+	// 非流式响应，后端为流式时合并所有chunk
+	responseJSON, err := upstream.readJSON()
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
@@ -218,9 +164,72 @@ func (h *Handler) HandleChatCompletions(w http.ResponseWriter, r *http.Request)
 		responseJSON["model"] = customModelID
 	}
 
+	if clientStream {
+		// 客户端要求流式但后端返回非流式，模拟为流式响应
+		if h.logger != nil {
+			h.logger.Debug("后端返回非流式响应，模拟流式输出")
+		}
+		if err := SimulateStream(r.Context(), w, responseJSON, customModelID, upstream.backend.Simulate); err != nil {
+			// 客户端断开由trackRequest统一记录
+			if h.logger != nil && r.Context().Err() == nil {
+				h.logger.Error("模拟流式响应失败: %v", err)
+			}
+		}
+		return
+	}
+
 	h.writeJSON(w, responseJSON)
 }
 
+// rewriteStream 解析后端的chunk流，经eachChunk改写后重新输出给客户端
+func (h *Handler) rewriteStream(w http.ResponseWriter, upstream *upstreamResponse, customModelID string) error {
+	out, err := newSSEWriter(w)
+	if err != nil {
+		return err
+	}
+	strip := upstream.usage.stripUsage()
+	if err := upstream.eachChunk(func(chunk map[string]interface{}) error {
+		if customModelID != "" && chunk["model"] != nil {
+			chunk["model"] = customModelID
+		}
+		// 客户端没有请求usage时删除代理注入的usage，只有usage的chunk不再输出
+		if strip && chunk["usage"] != nil {
+			delete(chunk, "usage")
+			if choices, _ := chunk["choices"].([]interface{}); len(choices) == 0 {
+				return nil
+			}
+		}
+		return out.writeEvent("", chunk)
+	}); err != nil {
+		return err
+	}
+	return out.writeDone()
+}
+
+// decodeJSONBody 校验Content-Type并解析JSON请求体
+func (h *Handler) decodeJSONBody(r *http.Request) (map[string]interface{}, error) {
+	// 检查Content-Type
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		return nil, fmt.Errorf("Content-Type必须为application/json")
+	}
+
+	// 解析请求体
+	var reqJSON map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&reqJSON); err != nil {
+		return nil, fmt.Errorf("无效的JSON请求体: %v", err)
+	}
+
+	// 调试日志
+	if h.logger != nil {
+		h.logger.Debug("请求头: %v", r.Header)
+		reqJSONBytes, _ := json.Marshal(reqJSON)
+		h.logger.Debug("请求体: %s", string(reqJSONBytes))
+	}
+
+	return reqJSON, nil
+}
+
 // writeJSON 写入JSON响应
 func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode ...int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -232,7 +241,16 @@ func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode
 	json.NewEncoder(w).Encode(data)
 }
 
-// writeError 写入错误响应
+// writeError 以OpenAI错误格式写入错误响应
 func (h *Handler) writeError(w http.ResponseWriter, message string, statusCode int) {
-	h.writeJSON(w, map[string]string{"error": message}, statusCode)
+	h.writeAPIError(w, &apiError{status: statusCode, message: message})
+}
+
+// writeProxyError 写入forwardChatCompletion返回的错误
+func (h *Handler) writeProxyError(w http.ResponseWriter, err error) {
+	if pe, ok := err.(*proxyError); ok {
+		h.writeAPIError(w, &apiError{status: pe.status, message: pe.message, code: pe.code, param: pe.param})
+		return
+	}
+	h.writeError(w, err.Error(), http.StatusInternalServerError)
 }
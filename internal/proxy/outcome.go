@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// statsLogInterval 请求统计写入日志的间隔，期间没有新请求时不输出
+const statsLogInterval = 10 * time.Minute
+
+// requestStats 按结果统计代理处理的请求数
+type requestStats struct {
+	succeeded atomic.Int64
+	failed    atomic.Int64
+	canceled  atomic.Int64 // 客户端在响应完成前断开
+}
+
+// requestCounts 请求统计的快照
+type requestCounts struct {
+	succeeded, failed, canceled int64
+}
+
+func (s *requestStats) snapshot() requestCounts {
+	return requestCounts{
+		succeeded: s.succeeded.Load(),
+		failed:    s.failed.Load(),
+		canceled:  s.canceled.Load(),
+	}
+}
+
+// logStats 将累计的请求统计写入日志
+func (h *Handler) logStats() {
+	if h.logger == nil {
+		return
+	}
+	c := h.stats.snapshot()
+	h.logger.Info("请求统计: 成功 %d 次，失败 %d 次，客户端取消 %d 次", c.succeeded, c.failed, c.canceled)
+}
+
+// reportStats 每隔statsLogInterval输出一次请求统计，与上次输出相同时跳过
+func (h *Handler) reportStats() {
+	ticker := time.NewTicker(statsLogInterval)
+	defer ticker.Stop()
+	var last requestCounts
+	for range ticker.C {
+		if current := h.stats.snapshot(); current != last {
+			last = current
+			h.logStats()
+		}
+	}
+}
+
+// statusRecorder 记录写出的状态码，并保留流式输出所需的Flush
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// trackRequest 统计请求结果，客户端中途断开的请求单独计为取消
+func (h *Handler) trackRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		// 处理函数返回前context已被取消，说明客户端已断开连接
+		if r.Context().Err() == context.Canceled {
+			total := h.stats.canceled.Add(1)
+			if h.logger != nil {
+				h.logger.Info("客户端取消请求: %s %s（耗时 %v，累计取消 %d 次）", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond), total)
+			}
+			return
+		}
+		if rec.status >= http.StatusBadRequest {
+			h.stats.failed.Add(1)
+		} else {
+			h.stats.succeeded.Add(1)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package proxy
+
+import "trae-proxy-go/pkg/models"
+
+// applyParamPreset 按后端的参数预设调整请求体
+// 依次删除remove中的字段、补充客户端未设置的defaults、强制写入overrides
+func applyParamPreset(reqJSON map[string]interface{}, preset *models.ParamPreset) {
+	if preset == nil {
+		return
+	}
+	for _, key := range preset.Remove {
+		delete(reqJSON, key)
+	}
+	for key, value := range preset.Defaults {
+		if _, ok := reqJSON[key]; !ok {
+			reqJSON[key] = value
+		}
+	}
+	for key, value := range preset.Overrides {
+		reqJSON[key] = value
+	}
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// 推理内容处理模式
+const (
+	reasoningPassthrough = "passthrough" // 原样返回（默认）
+	reasoningDrop        = "drop"        // 删除reasoning_content
+	reasoningFold        = "fold"        // 将reasoning_content以<think>...</think>形式并入content
+	reasoningExtract     = "extract"     // 将content中的<think>...</think>提取到reasoning_content
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// needsReasoningTransform 判断模式是否需要改写响应
+func needsReasoningTransform(mode string) bool {
+	return mode == reasoningDrop || mode == reasoningFold || mode == reasoningExtract
+}
+
+// transformReasoningMessage 按模式改写非流式响应中的单条消息
+func transformReasoningMessage(mode string, message map[string]interface{}) {
+	reasoning, _ := message["reasoning_content"].(string)
+	content, _ := message["content"].(string)
+
+	switch mode {
+	case reasoningDrop:
+		delete(message, "reasoning_content")
+	case reasoningFold:
+		delete(message, "reasoning_content")
+		if reasoning != "" {
+			message["content"] = thinkOpenTag + "\n" + reasoning + "\n" + thinkCloseTag + "\n\n" + content
+		}
+	case reasoningExtract:
+		start := strings.Index(content, thinkOpenTag)
+		if start < 0 {
+			return
+		}
+		rest := content[start+len(thinkOpenTag):]
+		end := strings.Index(rest, thinkCloseTag)
+		var extracted, after string
+		if end < 0 {
+			// 没有结束标签时其后的内容都视为推理
+			extracted = rest
+		} else {
+			extracted = rest[:end]
+			after = rest[end+len(thinkCloseTag):]
+		}
+		message["reasoning_content"] = reasoning + strings.TrimSpace(extracted)
+		message["content"] = content[:start] + strings.TrimLeft(after, "\r\n")
+	}
+}
+
+// transformReasoningResponse 改写chat.completion中所有choice的消息
+func transformReasoningResponse(mode string, resp map[string]interface{}) {
+	if !needsReasoningTransform(mode) {
+		return
+	}
+	choices, _ := resp["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if message, ok := choice["message"].(map[string]interface{}); ok {
+			transformReasoningMessage(mode, message)
+		}
+	}
+}
+
+// reasoningStream 流式响应的推理内容改写，按choice保存跨chunk的状态
+type reasoningStream struct {
+	mode    string
+	choices map[int]*reasoningChoiceState
+}
+
+// reasoningChoiceState 单个choice的改写状态
+type reasoningChoiceState struct {
+	thinkOpen bool   // fold: 已输出<think>尚未关闭；extract: 正处于<think>内
+	seenText  bool   // extract: 已经输出过正文或推理，用于去掉</think>后的换行
+	pending   string // extract: 可能是标签前缀、暂缓输出的文本
+}
+
+// newReasoningStream 创建流式改写器，模式无需改写时返回nil
+func newReasoningStream(mode string) *reasoningStream {
+	if !needsReasoningTransform(mode) {
+		return nil
+	}
+	return &reasoningStream{mode: mode, choices: map[int]*reasoningChoiceState{}}
+}
+
+// transform 改写一个chunk中各choice的delta
+func (s *reasoningStream) transform(chunk map[string]interface{}) {
+	choices, _ := chunk["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := intValue(choice["index"])
+		st, ok := s.choices[index]
+		if !ok {
+			st = &reasoningChoiceState{}
+			s.choices[index] = st
+		}
+
+		delta, _ := choice["delta"].(map[string]interface{})
+		if delta == nil {
+			delta = map[string]interface{}{}
+		}
+		finished := choice["finish_reason"] != nil
+
+		var reasoning, content string
+		switch s.mode {
+		case reasoningDrop:
+			delete(delta, "reasoning_content")
+			continue
+		case reasoningFold:
+			reasoning, content = st.fold(delta, finished)
+		case reasoningExtract:
+			reasoning, content = st.extract(delta, finished)
+		}
+
+		original, hadContent := delta["content"]
+		delete(delta, "reasoning_content")
+		delete(delta, "content")
+		if reasoning != "" {
+			delta["reasoning_content"] = reasoning
+		}
+		// 上游本身的空content保留，被暂缓输出的标签前缀不产生空content
+		if content != "" || (hadContent && original == "" && reasoning == "") {
+			delta["content"] = content
+		}
+		choice["delta"] = delta
+	}
+}
+
+// fold 将推理内容转换为<think>包裹的正文
+func (st *reasoningChoiceState) fold(delta map[string]interface{}, finished bool) (string, string) {
+	reasoning, _ := delta["reasoning_content"].(string)
+	content, _ := delta["content"].(string)
+
+	var b strings.Builder
+	if reasoning != "" {
+		if !st.thinkOpen {
+			st.thinkOpen = true
+			b.WriteString(thinkOpenTag + "\n")
+		}
+		b.WriteString(reasoning)
+	}
+	// 推理结束（出现正文或流结束）时关闭标签
+	if st.thinkOpen && (content != "" || finished) {
+		st.thinkOpen = false
+		b.WriteString("\n" + thinkCloseTag + "\n\n")
+	}
+	b.WriteString(content)
+	return "", b.String()
+}
+
+// extract 从正文中分离<think>...</think>，标签可能被拆分在多个chunk中
+func (st *reasoningChoiceState) extract(delta map[string]interface{}, finished bool) (string, string) {
+	reasoningIn, _ := delta["reasoning_content"].(string)
+	content, _ := delta["content"].(string)
+
+	text := st.pending + content
+	st.pending = ""
+	var reasoning, out strings.Builder
+	reasoning.WriteString(reasoningIn)
+
+	for text != "" {
+		tag := thinkOpenTag
+		if st.thinkOpen {
+			tag = thinkCloseTag
+		}
+		if i := strings.Index(text, tag); i >= 0 {
+			st.emit(&reasoning, &out, text[:i])
+			text = text[i+len(tag):]
+			st.thinkOpen = !st.thinkOpen
+			if !st.thinkOpen {
+				// 去掉</think>之后紧跟的换行
+				text = strings.TrimLeft(text, "\r\n")
+				st.seenText = false
+			}
+			continue
+		}
+		// 末尾可能是标签的前半部分，留到下一个chunk再判断
+		keep := partialTagSuffix(text, tag)
+		if finished {
+			keep = 0
+		}
+		st.emit(&reasoning, &out, text[:len(text)-keep])
+		st.pending = text[len(text)-keep:]
+		break
+	}
+	return reasoning.String(), out.String()
+}
+
+// emit 按当前是否处于<think>内输出文本
+func (st *reasoningChoiceState) emit(reasoning, out *strings.Builder, text string) {
+	if text == "" {
+		return
+	}
+	if st.thinkOpen {
+		if !st.seenText {
+			text = strings.TrimLeft(text, "\r\n")
+		}
+		reasoning.WriteString(text)
+	} else {
+		if !st.seenText {
+			text = strings.TrimLeft(text, "\r\n")
+		}
+		out.WriteString(text)
+	}
+	if text != "" {
+		st.seenText = true
+	}
+}
+
+// partialTagSuffix 返回text末尾与tag前缀重合的长度
+func partialTagSuffix(text, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
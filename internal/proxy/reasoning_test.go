@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// contentChunk 构造只包含一个choice的流式chunk
+func contentChunk(content string, finishReason interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"model": "test-model",
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"delta":         map[string]interface{}{"content": content},
+				"finish_reason": finishReason,
+			},
+		},
+	}
+}
+
+// runReasoningStream 依次改写chunk，返回拼接后的推理和正文，以及被丢弃的空chunk数
+func runReasoningStream(t *testing.T, mode string, chunks []map[string]interface{}) (string, string, int) {
+	t.Helper()
+	s := newReasoningStream(mode)
+	var reasoning, content string
+	empty := 0
+	for _, chunk := range chunks {
+		s.transform(chunk)
+		if emptyChunk(chunk) {
+			empty++
+			continue
+		}
+		choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+		delta := choice["delta"].(map[string]interface{})
+		if text, ok := delta["reasoning_content"].(string); ok {
+			reasoning += text
+		}
+		if text, ok := delta["content"].(string); ok {
+			if text == "" {
+				t.Errorf("改写后产生了空content: %v", chunk)
+			}
+			content += text
+		}
+	}
+	return reasoning, content, empty
+}
+
+func TestReasoningExtractStream(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		wantReasoning string
+		wantContent   string
+		wantEmpty     int
+	}{
+		{
+			name:          "完整标签",
+			chunks:        []string{"<think>思考</think>\n\n回答"},
+			wantReasoning: "思考",
+			wantContent:   "回答",
+		},
+		{
+			name:          "标签拆分在多个chunk中",
+			chunks:        []string{"<thi", "nk>abc</th", "ink>\nhello"},
+			wantReasoning: "abc",
+			wantContent:   "hello",
+			wantEmpty:     1,
+		},
+		{
+			name:          "逐字符拆分",
+			chunks:        []string{"<", "t", "h", "i", "n", "k", ">", "x", "<", "/", "think>", "y"},
+			wantReasoning: "x",
+			wantContent:   "y",
+			wantEmpty:     10,
+		},
+		{
+			name:          "缺少结束标签",
+			chunks:        []string{"<think>abc", "def"},
+			wantReasoning: "abcdef",
+		},
+		{
+			name:        "没有标签",
+			chunks:      []string{"a < b", " and c"},
+			wantContent: "a < b and c",
+		},
+		{
+			name:        "结束时输出暂缓的标签前缀",
+			chunks:      []string{"answer <th"},
+			wantContent: "answer <th",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks []map[string]interface{}
+			for i, text := range tt.chunks {
+				var finish interface{}
+				if i == len(tt.chunks)-1 {
+					finish = "stop"
+				}
+				chunks = append(chunks, contentChunk(text, finish))
+			}
+			reasoning, content, empty := runReasoningStream(t, reasoningExtract, chunks)
+			if reasoning != tt.wantReasoning {
+				t.Errorf("reasoning = %q, want %q", reasoning, tt.wantReasoning)
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if empty != tt.wantEmpty {
+				t.Errorf("空chunk数 = %d, want %d", empty, tt.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestReasoningExtractStreamKeepsUpstreamEmptyContent(t *testing.T) {
+	chunk := map[string]interface{}{
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index": 0,
+				"delta": map[string]interface{}{"role": "assistant", "content": ""},
+			},
+		},
+	}
+	newReasoningStream(reasoningExtract).transform(chunk)
+	delta := chunk["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+	if content, ok := delta["content"]; !ok || content != "" {
+		t.Errorf("上游的空content应保留, delta = %v", delta)
+	}
+}
+
+func TestReasoningExtractMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		reasoning     string
+		wantContent   string
+		wantReasoning interface{}
+	}{
+		{
+			name:          "提取推理",
+			content:       "<think>\n思考\n</think>\n\n回答",
+			wantContent:   "回答",
+			wantReasoning: "思考",
+		},
+		{
+			name:          "标签前的正文保留",
+			content:       "前言<think>r</think>后文",
+			wantContent:   "前言后文",
+			wantReasoning: "r",
+		},
+		{
+			name:          "缺少结束标签",
+			content:       "<think>未完成的推理",
+			wantContent:   "",
+			wantReasoning: "未完成的推理",
+		},
+		{
+			name:          "没有标签",
+			content:       "普通回答",
+			wantContent:   "普通回答",
+			wantReasoning: nil,
+		},
+		{
+			name:          "追加到已有推理",
+			content:       "<think>b</think>c",
+			reasoning:     "a",
+			wantContent:   "c",
+			wantReasoning: "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := map[string]interface{}{"role": "assistant", "content": tt.content}
+			if tt.reasoning != "" {
+				message["reasoning_content"] = tt.reasoning
+			}
+			transformReasoningMessage(reasoningExtract, message)
+			if message["content"] != tt.wantContent {
+				t.Errorf("content = %q, want %q", message["content"], tt.wantContent)
+			}
+			if message["reasoning_content"] != tt.wantReasoning {
+				t.Errorf("reasoning_content = %v, want %v", message["reasoning_content"], tt.wantReasoning)
+			}
+		})
+	}
+}
+
+func TestReasoningFoldStream(t *testing.T) {
+	s := newReasoningStream(reasoningFold)
+	chunks := []map[string]interface{}{
+		{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"reasoning_content": "想"}}}},
+		{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"content": "答"}}}},
+		{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": "stop"}}},
+	}
+	var content string
+	for _, chunk := range chunks {
+		s.transform(chunk)
+		delta := chunk["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+		if _, ok := delta["reasoning_content"]; ok {
+			t.Fatalf("fold模式不应输出reasoning_content: %v", delta)
+		}
+		text, _ := delta["content"].(string)
+		content += text
+	}
+	if want := "<think>\n想\n</think>\n\n答"; content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// responseStoreMaxEntries 最多保存的响应数量，超出后淘汰最早的记录
+	responseStoreMaxEntries = 1000
+	// responseStoreTTL 响应记录的保留时长
+	responseStoreTTL = 24 * time.Hour
+)
+
+// storedResponse 保存的Responses API响应及其完整对话历史
+type storedResponse struct {
+	response map[string]interface{}
+	messages []interface{} // 不含instructions的chat消息历史（包括本次输出）
+	created  time.Time
+}
+
+// responseStore 本地内存中的Responses存储，用于支持previous_response_id
+// 后端是无状态的chat/completions接口，多轮对话的历史由代理保存
+type responseStore struct {
+	mu      sync.Mutex
+	entries map[string]*storedResponse
+	order   []string
+}
+
+func newResponseStore() *responseStore {
+	return &responseStore{entries: map[string]*storedResponse{}}
+}
+
+// get 读取未过期的响应记录
+func (s *responseStore) get(id string) (*storedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.created) > responseStoreTTL {
+		delete(s.entries, id)
+		return nil, false
+	}
+	return entry, true
+}
+
+// put 保存响应记录，并淘汰过期或超出容量的记录
+func (s *responseStore) put(id string, entry *storedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.entries[id] = entry
+
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		old, ok := s.entries[oldest]
+		if ok && len(s.entries) <= responseStoreMaxEntries && time.Since(old.created) <= responseStoreTTL {
+			break
+		}
+		delete(s.entries, oldest)
+		s.order = s.order[1:]
+	}
+}
+
+// delete 删除响应记录
+func (s *responseStore) delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return false
+	}
+	delete(s.entries, id)
+	return true
+}
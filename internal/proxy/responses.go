@@ -0,0 +1,875 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HandleResponses 处理OpenAI Responses API请求，转换为chat/completions后转发到后端
+func (h *Handler) HandleResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respReq, err := h.decodeJSONBody(r)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 展开previous_response_id对应的历史
+	var history []interface{}
+	previousID, _ := respReq["previous_response_id"].(string)
+	if previousID != "" {
+		prev, ok := h.responses.get(previousID)
+		if !ok {
+			h.writeError(w, fmt.Sprintf("未找到previous_response_id对应的响应: %s", previousID), http.StatusNotFound)
+			return
+		}
+		history = append(history, prev.messages...)
+	}
+
+	inputMessages, err := responsesInputToChat(respReq["input"])
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conversation := append(history, inputMessages...)
+
+	chatReq := responsesToChatRequest(respReq, conversation)
+	upstream, err := h.forwardChatCompletion(r, chatReq)
+	if err != nil {
+		h.writeProxyError(w, err)
+		return
+	}
+	resp := upstream.resp
+	defer resp.Body.Close()
+	upstream.setContextHeaders(w)
+	requestedStream, _ := respReq["stream"].(bool)
+	clientStream := upstream.clientStream(requestedStream)
+	defer h.recordUsage(r, upstream, clientStream)
+
+	if resp.StatusCode >= 400 {
+		errorBody, _ := io.ReadAll(resp.Body)
+		h.writeAPIError(w, normalizeUpstreamError(resp.StatusCode, errorBody))
+		return
+	}
+
+	builder := newResponsesBuilder(respReq, upstream.model)
+	if clientStream {
+		if err := builder.stream(w, upstream.eachChunk); err != nil {
+			if h.logger != nil && r.Context().Err() == nil {
+				h.logger.Error("Responses流式响应处理失败: %v", err)
+			}
+			return
+		}
+	} else {
+		chatResp, err := upstream.readJSON()
+		if err != nil {
+			h.writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		builder.fromChatResponse(chatResp)
+		h.writeJSON(w, builder.response("completed"))
+	}
+
+	// store默认为true
+	if store, ok := respReq["store"].(bool); !ok || store {
+		h.responses.put(builder.id, &storedResponse{
+			response: builder.response("completed"),
+			messages: append(conversation, builder.assistantMessage()),
+			created:  time.Now(),
+		})
+	}
+}
+
+// HandleResponseByID 处理 /v1/responses/{id} 的查询与删除
+func (h *Handler) HandleResponseByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/responses/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		h.writeError(w, "无效的响应ID", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, ok := h.responses.get(id)
+		if !ok {
+			h.writeError(w, fmt.Sprintf("未找到响应: %s", id), http.StatusNotFound)
+			return
+		}
+		h.writeJSON(w, entry.response)
+	case http.MethodDelete:
+		if !h.responses.delete(id) {
+			h.writeError(w, fmt.Sprintf("未找到响应: %s", id), http.StatusNotFound)
+			return
+		}
+		h.writeJSON(w, map[string]interface{}{"id": id, "object": "response.deleted", "deleted": true})
+	default:
+		h.writeError(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
+
+// responsesToChatRequest 将Responses请求参数转换为chat/completions请求
+func responsesToChatRequest(req map[string]interface{}, conversation []interface{}) map[string]interface{} {
+	chatReq := map[string]interface{}{}
+	chatReq["model"], _ = req["model"].(string)
+
+	var messages []interface{}
+	if instructions, ok := req["instructions"].(string); ok && instructions != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": instructions})
+	}
+	chatReq["messages"] = append(messages, conversation...)
+
+	if v, ok := req["max_output_tokens"]; ok {
+		chatReq["max_tokens"] = v
+	}
+	for _, key := range []string{"temperature", "top_p", "stream", "parallel_tool_calls", "user"} {
+		if v, ok := req[key]; ok {
+			chatReq[key] = v
+		}
+	}
+
+	if tools, ok := req["tools"].([]interface{}); ok {
+		var chatTools []interface{}
+		for _, raw := range tools {
+			tool, ok := raw.(map[string]interface{})
+			if !ok || tool["type"] != "function" {
+				// 内置工具（web_search等）后端无法执行，直接忽略
+				continue
+			}
+			function := map[string]interface{}{"name": tool["name"]}
+			for _, key := range []string{"description", "parameters", "strict"} {
+				if v, ok := tool[key]; ok {
+					function[key] = v
+				}
+			}
+			chatTools = append(chatTools, map[string]interface{}{"type": "function", "function": function})
+		}
+		if len(chatTools) > 0 {
+			chatReq["tools"] = chatTools
+		}
+	}
+
+	switch choice := req["tool_choice"].(type) {
+	case string:
+		chatReq["tool_choice"] = choice
+	case map[string]interface{}:
+		if choice["type"] == "function" {
+			chatReq["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": choice["name"]},
+			}
+		}
+	}
+
+	if text, ok := req["text"].(map[string]interface{}); ok {
+		if format, ok := text["format"].(map[string]interface{}); ok {
+			switch format["type"] {
+			case "json_object":
+				chatReq["response_format"] = map[string]interface{}{"type": "json_object"}
+			case "json_schema":
+				schema := map[string]interface{}{"name": format["name"], "schema": format["schema"]}
+				if strict, ok := format["strict"]; ok {
+					schema["strict"] = strict
+				}
+				chatReq["response_format"] = map[string]interface{}{"type": "json_schema", "json_schema": schema}
+			}
+		}
+	}
+
+	return chatReq
+}
+
+// responsesInputToChat 将Responses的input转换为chat消息
+func responsesInputToChat(input interface{}) ([]interface{}, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []interface{}{map[string]interface{}{"role": "user", "content": v}}, nil
+	case []interface{}:
+		var messages []interface{}
+		for _, raw := range v {
+			item, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("无效的input项")
+			}
+			itemType, _ := item["type"].(string)
+			if itemType == "" && item["role"] != nil {
+				itemType = "message"
+			}
+
+			switch itemType {
+			case "message":
+				role, _ := item["role"].(string)
+				if role == "developer" {
+					role = "system"
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":    role,
+					"content": responsesContentToChat(item["content"]),
+				})
+			case "function_call":
+				call := map[string]interface{}{
+					"id":   item["call_id"],
+					"type": "function",
+					"function": map[string]interface{}{
+						"name":      item["name"],
+						"arguments": item["arguments"],
+					},
+				}
+				// 连续的function_call合并到同一条assistant消息
+				if n := len(messages); n > 0 {
+					if last, ok := messages[n-1].(map[string]interface{}); ok && last["role"] == "assistant" {
+						calls, _ := last["tool_calls"].([]interface{})
+						last["tool_calls"] = append(calls, call)
+						continue
+					}
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":       "assistant",
+					"content":    nil,
+					"tool_calls": []interface{}{call},
+				})
+			case "function_call_output":
+				output, ok := item["output"].(string)
+				if !ok {
+					data, _ := json.Marshal(item["output"])
+					output = string(data)
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":         "tool",
+					"tool_call_id": item["call_id"],
+					"content":      output,
+				})
+			}
+			// reasoning等其他类型的项不转发给后端
+		}
+		return messages, nil
+	}
+	return nil, fmt.Errorf("input 字段必须为字符串或数组")
+}
+
+// responsesContentToChat 转换消息内容，含图片时保留为多段内容
+func responsesContentToChat(content interface{}) interface{} {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content
+	}
+
+	var chatParts []interface{}
+	var texts []string
+	hasImage := false
+	for _, raw := range parts {
+		part, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "input_text", "output_text", "text":
+			text, _ := part["text"].(string)
+			texts = append(texts, text)
+			chatParts = append(chatParts, map[string]interface{}{"type": "text", "text": text})
+		case "input_image":
+			url, _ := part["image_url"].(string)
+			if url == "" {
+				continue
+			}
+			hasImage = true
+			imageURL := map[string]interface{}{"url": url}
+			if detail, ok := part["detail"]; ok {
+				imageURL["detail"] = detail
+			}
+			chatParts = append(chatParts, map[string]interface{}{"type": "image_url", "image_url": imageURL})
+		}
+	}
+	if hasImage {
+		return chatParts
+	}
+	return strings.Join(texts, "\n")
+}
+
+// responsesBuilder 根据chat响应构建Responses对象，同时支持流式事件输出
+type responsesBuilder struct {
+	id         string
+	createdAt  int64
+	model      string
+	request    map[string]interface{}
+	output     []map[string]interface{}
+	text       strings.Builder
+	reasoning  strings.Builder
+	toolCalls  []map[string]interface{}
+	status     string
+	incomplete string
+	usage      map[string]interface{}
+}
+
+func newResponsesBuilder(req map[string]interface{}, model string) *responsesBuilder {
+	return &responsesBuilder{
+		id:        "resp_" + randomHex(24),
+		createdAt: time.Now().Unix(),
+		model:     model,
+		request:   req,
+		status:    "completed",
+	}
+}
+
+// fromChatResponse 从非流式chat.completion响应填充输出
+func (b *responsesBuilder) fromChatResponse(chatResp map[string]interface{}) {
+	if choices, ok := chatResp["choices"].([]interface{}); ok && len(choices) > 0 {
+		choice, _ := choices[0].(map[string]interface{})
+		message, _ := choice["message"].(map[string]interface{})
+		if reasoning, ok := message["reasoning_content"].(string); ok {
+			b.reasoning.WriteString(reasoning)
+		}
+		if text, ok := message["content"].(string); ok {
+			b.text.WriteString(text)
+		}
+		if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
+			for _, raw := range toolCalls {
+				call, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				function, _ := call["function"].(map[string]interface{})
+				args, _ := function["arguments"].(string)
+				b.toolCalls = append(b.toolCalls, map[string]interface{}{
+					"type":      "function_call",
+					"id":        "fc_" + randomHex(24),
+					"call_id":   call["id"],
+					"name":      function["name"],
+					"arguments": args,
+					"status":    "completed",
+				})
+			}
+		}
+		if reason, _ := choice["finish_reason"].(string); reason == "length" {
+			b.status = "incomplete"
+			b.incomplete = "max_output_tokens"
+		}
+	}
+	b.setUsage(chatResp["usage"])
+	b.output = b.buildOutput()
+}
+
+func (b *responsesBuilder) setUsage(v interface{}) {
+	usage, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	input, output := chatUsageTokens(usage)
+	b.usage = map[string]interface{}{
+		"input_tokens":  input,
+		"output_tokens": output,
+		"total_tokens":  input + output,
+	}
+}
+
+// buildOutput 按reasoning、message、function_call的顺序生成输出项
+func (b *responsesBuilder) buildOutput() []map[string]interface{} {
+	var output []map[string]interface{}
+	if b.reasoning.Len() > 0 {
+		output = append(output, b.reasoningItem())
+	}
+	if b.text.Len() > 0 {
+		output = append(output, b.messageItem("completed"))
+	}
+	output = append(output, b.toolCalls...)
+	return output
+}
+
+func (b *responsesBuilder) reasoningItem() map[string]interface{} {
+	return newReasoningItem(b.itemID("rs_", 0), b.reasoning.String())
+}
+
+func (b *responsesBuilder) messageItem(status string) map[string]interface{} {
+	return newMessageItem(b.itemID("msg_", 0), status, b.text.String())
+}
+
+// itemID 生成输出项ID，同类输出项出现多次时第n个（从0开始）带有序号后缀
+func (b *responsesBuilder) itemID(prefix string, n int) string {
+	id := prefix + strings.TrimPrefix(b.id, "resp_")
+	if n > 0 {
+		id += fmt.Sprintf("_%d", n)
+	}
+	return id
+}
+
+func newReasoningItem(id, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "reasoning",
+		"id":      id,
+		"summary": []interface{}{map[string]interface{}{"type": "summary_text", "text": text}},
+	}
+}
+
+func newMessageItem(id, status, text string) map[string]interface{} {
+	content := []interface{}{}
+	if status == "completed" {
+		content = append(content, outputTextPart(text))
+	}
+	return map[string]interface{}{
+		"type":    "message",
+		"id":      id,
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+func outputTextPart(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}}
+}
+
+// response 生成完整的response对象
+func (b *responsesBuilder) response(status string) map[string]interface{} {
+	if status == "completed" {
+		status = b.status
+	}
+	output := b.output
+	if output == nil {
+		output = []map[string]interface{}{}
+	}
+	resp := map[string]interface{}{
+		"id":                   b.id,
+		"object":               "response",
+		"created_at":           b.createdAt,
+		"status":               status,
+		"model":                b.model,
+		"output":               output,
+		"parallel_tool_calls":  true,
+		"previous_response_id": nil,
+		"usage":                b.usage,
+	}
+	for _, key := range []string{"instructions", "previous_response_id", "tools", "tool_choice", "temperature", "top_p", "max_output_tokens", "metadata", "text", "parallel_tool_calls"} {
+		if v, ok := b.request[key]; ok {
+			resp[key] = v
+		}
+	}
+	if b.incomplete != "" && status == "incomplete" {
+		resp["incomplete_details"] = map[string]interface{}{"reason": b.incomplete}
+	}
+	return resp
+}
+
+// assistantMessage 将本次输出转换为chat消息，用于保存对话历史
+func (b *responsesBuilder) assistantMessage() map[string]interface{} {
+	msg := map[string]interface{}{"role": "assistant", "content": b.text.String()}
+	if len(b.toolCalls) > 0 {
+		var calls []interface{}
+		for _, item := range b.toolCalls {
+			calls = append(calls, map[string]interface{}{
+				"id":   item["call_id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      item["name"],
+					"arguments": item["arguments"],
+				},
+			})
+		}
+		msg["tool_calls"] = calls
+		if b.text.Len() == 0 {
+			msg["content"] = nil
+		}
+	}
+	return msg
+}
+
+// responsesStreamState 输出Responses流式事件时的状态
+// 输出项按出现顺序分配output_index，结束时的response.output使用相同的顺序；
+// 输出项发送output_item.done之后不能再有它的delta，因此function_call输出项打开后保持打开直到流结束，
+// 期间到达的正文、推理和其他工具调用先缓存，结束时按到达顺序输出
+type responsesStreamState struct {
+	b        *responsesBuilder
+	out      *sseWriter
+	seq      int
+	items    []*streamItem     // 按output_index排列的输出项
+	open     *streamItem       // 当前打开的输出项
+	liveTool int               // 正在流式输出参数的chat tool_calls序号，-1表示没有
+	deferred []*deferredOutput // function_call输出项打开期间缓存的内容
+	counts   map[string]int    // 各类型已打开的输出项数，用于生成ID
+}
+
+// deferredOutput 缓存的输出项内容，流结束时一次性输出
+type deferredOutput struct {
+	kind      string // reasoning/message/function_call
+	callIndex int    // function_call: chat tool_calls序号
+	callID    interface{}
+	name      string
+	text      strings.Builder // 推理、正文或工具调用参数
+}
+
+// streamItem 流式输出中的一个输出项
+type streamItem struct {
+	kind  string // reasoning/message/function_call
+	id    string
+	index int // output_index
+	text  strings.Builder
+	call  map[string]interface{} // function_call输出项
+	done  bool
+}
+
+// item 生成输出项对象，未结束的message为in_progress
+func (it *streamItem) item() map[string]interface{} {
+	switch it.kind {
+	case "reasoning":
+		return newReasoningItem(it.id, it.text.String())
+	case "message":
+		status := "completed"
+		if !it.done {
+			status = "in_progress"
+		}
+		return newMessageItem(it.id, status, it.text.String())
+	}
+	return copyMap(it.call)
+}
+
+// stream 读取后端chat chunk并输出Responses流式事件
+func (b *responsesBuilder) stream(w http.ResponseWriter, eachChunk func(fn func(chunk map[string]interface{}) error) error) error {
+	out, err := newSSEWriter(w)
+	if err != nil {
+		return err
+	}
+	st := &responsesStreamState{b: b, out: out, liveTool: -1, counts: map[string]int{}}
+
+	if err := st.emit("response.created", map[string]interface{}{"response": b.response("in_progress")}); err != nil {
+		return err
+	}
+	if err := st.emit("response.in_progress", map[string]interface{}{"response": b.response("in_progress")}); err != nil {
+		return err
+	}
+
+	if err := eachChunk(st.handleChunk); err != nil {
+		st.fail(err)
+		return err
+	}
+	if err := st.flushDeferred(); err != nil {
+		return err
+	}
+
+	b.output = st.output()
+	event := "response.completed"
+	if b.status == "incomplete" {
+		event = "response.incomplete"
+	}
+	return st.emit(event, map[string]interface{}{"response": b.response("completed")})
+}
+
+// output 按output_index顺序生成response.output
+func (st *responsesStreamState) output() []map[string]interface{} {
+	var output []map[string]interface{}
+	for _, it := range st.items {
+		output = append(output, it.item())
+	}
+	return output
+}
+
+// fail 后端流中断时发送response.failed；客户端可能已经断开，写入失败时忽略
+func (st *responsesStreamState) fail(err error) {
+	st.b.output = st.output()
+	resp := st.b.response("failed")
+	resp["error"] = map[string]interface{}{"code": "server_error", "message": err.Error()}
+	st.emit("response.failed", map[string]interface{}{"response": resp})
+}
+
+func (st *responsesStreamState) emit(event string, payload map[string]interface{}) error {
+	payload["type"] = event
+	payload["sequence_number"] = st.seq
+	st.seq++
+	return st.out.writeEvent(event, payload)
+}
+
+// addItem 追加一个输出项并设为当前打开的输出项，reasoning和message的ID按出现次数生成
+func (st *responsesStreamState) addItem(kind, id string) *streamItem {
+	if id == "" {
+		prefix := "msg_"
+		if kind == "reasoning" {
+			prefix = "rs_"
+		}
+		id = st.b.itemID(prefix, st.counts[kind])
+	}
+	st.counts[kind]++
+	it := &streamItem{kind: kind, id: id, index: len(st.items)}
+	st.items = append(st.items, it)
+	st.open = it
+	return it
+}
+
+func (st *responsesStreamState) handleChunk(chunk map[string]interface{}) error {
+	b := st.b
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		b.setUsage(usage)
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	delta, _ := choice["delta"].(map[string]interface{})
+
+	if reasoning, ok := delta["reasoning_content"].(string); ok && reasoning != "" {
+		b.reasoning.WriteString(reasoning)
+		if err := st.textDelta("reasoning", reasoning); err != nil {
+			return err
+		}
+	}
+
+	if text, ok := delta["content"].(string); ok && text != "" {
+		b.text.WriteString(text)
+		if err := st.textDelta("message", text); err != nil {
+			return err
+		}
+	}
+
+	if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+		for _, raw := range toolCalls {
+			call, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := st.toolDelta(call); err != nil {
+				return err
+			}
+		}
+	}
+
+	if reason, _ := choice["finish_reason"].(string); reason == "length" {
+		b.status = "incomplete"
+		b.incomplete = "max_output_tokens"
+	}
+	return nil
+}
+
+// textDelta 输出推理或正文，function_call输出项打开期间缓存
+func (st *responsesStreamState) textDelta(kind, text string) error {
+	if st.liveTool >= 0 {
+		last := len(st.deferred) - 1
+		if last < 0 || st.deferred[last].kind != kind {
+			st.deferred = append(st.deferred, &deferredOutput{kind: kind})
+			last++
+		}
+		st.deferred[last].text.WriteString(text)
+		return nil
+	}
+
+	it, err := st.openItem(kind)
+	if err != nil {
+		return err
+	}
+	it.text.WriteString(text)
+	if kind == "reasoning" {
+		return st.emit("response.reasoning_summary_text.delta", map[string]interface{}{
+			"item_id":       it.id,
+			"output_index":  it.index,
+			"summary_index": 0,
+			"delta":         text,
+		})
+	}
+	return st.emit("response.output_text.delta", map[string]interface{}{
+		"item_id":       it.id,
+		"output_index":  it.index,
+		"content_index": 0,
+		"delta":         text,
+	})
+}
+
+// toolDelta 输出工具调用：第一个工具调用流式输出参数，其余工具调用缓存到流结束
+func (st *responsesStreamState) toolDelta(call map[string]interface{}) error {
+	idx := intValue(call["index"])
+	function, _ := call["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	args, _ := function["arguments"].(string)
+
+	if st.liveTool < 0 {
+		if _, err := st.openFunctionCall(call["id"], name); err != nil {
+			return err
+		}
+		st.liveTool = idx
+	}
+	if idx == st.liveTool {
+		return st.functionCallArguments(st.open, args)
+	}
+
+	var d *deferredOutput
+	for _, item := range st.deferred {
+		if item.kind == "function_call" && item.callIndex == idx {
+			d = item
+			break
+		}
+	}
+	if d == nil {
+		d = &deferredOutput{kind: "function_call", callIndex: idx, callID: call["id"], name: name}
+		st.deferred = append(st.deferred, d)
+	}
+	d.text.WriteString(args)
+	return nil
+}
+
+// openFunctionCall 关闭当前输出项并打开一个function_call输出项
+func (st *responsesStreamState) openFunctionCall(callID interface{}, name string) (*streamItem, error) {
+	if err := st.closeItem(); err != nil {
+		return nil, err
+	}
+	it := st.addItem("function_call", "fc_"+randomHex(24))
+	it.call = map[string]interface{}{
+		"type":      "function_call",
+		"id":        it.id,
+		"call_id":   callID,
+		"name":      name,
+		"arguments": "",
+		"status":    "in_progress",
+	}
+	st.b.toolCalls = append(st.b.toolCalls, it.call)
+	return it, st.emit("response.output_item.added", map[string]interface{}{
+		"output_index": it.index,
+		"item":         it.item(),
+	})
+}
+
+// functionCallArguments 追加function_call输出项的参数
+func (st *responsesStreamState) functionCallArguments(it *streamItem, args string) error {
+	if args == "" {
+		return nil
+	}
+	it.call["arguments"] = it.call["arguments"].(string) + args
+	return st.emit("response.function_call_arguments.delta", map[string]interface{}{
+		"item_id":      it.id,
+		"output_index": it.index,
+		"delta":        args,
+	})
+}
+
+// flushDeferred 关闭当前输出项后依次输出缓存的内容
+func (st *responsesStreamState) flushDeferred() error {
+	if err := st.closeItem(); err != nil {
+		return err
+	}
+	st.liveTool = -1
+	for _, d := range st.deferred {
+		if d.kind == "function_call" {
+			it, err := st.openFunctionCall(d.callID, d.name)
+			if err != nil {
+				return err
+			}
+			if err := st.functionCallArguments(it, d.text.String()); err != nil {
+				return err
+			}
+		} else if err := st.textDelta(d.kind, d.text.String()); err != nil {
+			return err
+		}
+		if err := st.closeItem(); err != nil {
+			return err
+		}
+	}
+	st.deferred = nil
+	return nil
+}
+
+// openItem 返回当前打开的reasoning或message输出项，类型不同时关闭当前输出项并打开新的
+func (st *responsesStreamState) openItem(kind string) (*streamItem, error) {
+	if st.open != nil && st.open.kind == kind {
+		return st.open, nil
+	}
+	if err := st.closeItem(); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "reasoning":
+		it := st.addItem(kind, "")
+		item := it.item()
+		item["summary"] = []interface{}{}
+		if err := st.emit("response.output_item.added", map[string]interface{}{"output_index": it.index, "item": item}); err != nil {
+			return nil, err
+		}
+		return it, st.emit("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id":       it.id,
+			"output_index":  it.index,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": ""},
+		})
+	default:
+		it := st.addItem(kind, "")
+		if err := st.emit("response.output_item.added", map[string]interface{}{"output_index": it.index, "item": it.item()}); err != nil {
+			return nil, err
+		}
+		return it, st.emit("response.content_part.added", map[string]interface{}{
+			"item_id":       it.id,
+			"output_index":  it.index,
+			"content_index": 0,
+			"part":          outputTextPart(""),
+		})
+	}
+}
+
+// closeItem 为当前打开的输出项发送done事件
+func (st *responsesStreamState) closeItem() error {
+	it := st.open
+	if it == nil {
+		return nil
+	}
+	st.open = nil
+	it.done = true
+	idx := it.index
+	text := it.text.String()
+
+	switch it.kind {
+	case "reasoning":
+		if err := st.emit("response.reasoning_summary_text.done", map[string]interface{}{
+			"item_id": it.id, "output_index": idx, "summary_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := st.emit("response.reasoning_summary_part.done", map[string]interface{}{
+			"item_id": it.id, "output_index": idx, "summary_index": 0,
+			"part": map[string]interface{}{"type": "summary_text", "text": text},
+		}); err != nil {
+			return err
+		}
+	case "message":
+		if err := st.emit("response.output_text.done", map[string]interface{}{
+			"item_id": it.id, "output_index": idx, "content_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := st.emit("response.content_part.done", map[string]interface{}{
+			"item_id": it.id, "output_index": idx, "content_index": 0, "part": outputTextPart(text),
+		}); err != nil {
+			return err
+		}
+	case "function_call":
+		it.call["status"] = "completed"
+		if err := st.emit("response.function_call_arguments.done", map[string]interface{}{
+			"item_id": it.id, "output_index": idx, "arguments": it.call["arguments"],
+		}); err != nil {
+			return err
+		}
+	}
+	return st.emit("response.output_item.done", map[string]interface{}{"output_index": idx, "item": it.item()})
+}
+
+// copyMap 浅拷贝map，避免后续修改影响已发送的事件
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// randomHex 生成指定长度的随机十六进制字符串
+func randomHex(n int) string {
+	buf := make([]byte, (n+1)/2)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)[:n]
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// responsesOutput 客户端按事件还原的输出项
+type responsesOutput struct {
+	Type string
+	Text string // 正文、推理或拼接后的工具参数
+}
+
+// replayResponses 按Responses协议还原输出项，同时检查输出项在done之后没有再收到delta
+func replayResponses(t *testing.T, events []map[string]interface{}) []responsesOutput {
+	t.Helper()
+	var items []responsesOutput
+	done := map[int]bool{}
+	for _, ev := range events {
+		switch ev["type"] {
+		case "response.output_item.added":
+			index := intValue(ev["output_index"])
+			if index != len(items) {
+				t.Fatalf("output_index %d 不连续", index)
+			}
+			items = append(items, responsesOutput{Type: ev["item"].(map[string]interface{})["type"].(string)})
+		case "response.output_text.delta", "response.reasoning_summary_text.delta", "response.function_call_arguments.delta":
+			index := intValue(ev["output_index"])
+			if done[index] || index >= len(items) {
+				t.Fatalf("输出项%d在output_item.done之后或added之前收到%s", index, ev["type"])
+			}
+			items[index].Text += ev["delta"].(string)
+		case "response.output_item.done":
+			done[intValue(ev["output_index"])] = true
+		}
+	}
+	for i := range items {
+		if !done[i] {
+			t.Errorf("输出项%d没有done", i)
+		}
+	}
+	return items
+}
+
+func TestResponsesStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []map[string]interface{}
+		want   []responsesOutput
+	}{
+		{
+			name: "工具参数之间穿插正文",
+			chunks: []map[string]interface{}{
+				contentChunk("先看看", nil),
+				toolCallChunk(0, "call_1", "read", `{"path":`),
+				contentChunk("穿插的正文", nil),
+				toolCallChunk(0, "", "", `"a.go"}`),
+				contentChunk("", "tool_calls"),
+			},
+			want: []responsesOutput{
+				{Type: "message", Text: "先看看"},
+				{Type: "function_call", Text: `{"path":"a.go"}`},
+				{Type: "message", Text: "穿插的正文"},
+			},
+		},
+		{
+			name: "并行工具调用的参数交替到达",
+			chunks: []map[string]interface{}{
+				toolCallChunk(0, "call_1", "a", `{"x"`),
+				toolCallChunk(1, "call_2", "b", `{"y"`),
+				toolCallChunk(0, "", "", `:1}`),
+				toolCallChunk(1, "", "", `:2}`),
+				contentChunk("", "tool_calls"),
+			},
+			want: []responsesOutput{
+				{Type: "function_call", Text: `{"x":1}`},
+				{Type: "function_call", Text: `{"y":2}`},
+			},
+		},
+		{
+			name: "推理和正文",
+			chunks: []map[string]interface{}{
+				{"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"reasoning_content": "想"}}}},
+				contentChunk("答", nil),
+				contentChunk("", "stop"),
+			},
+			want: []responsesOutput{
+				{Type: "reasoning", Text: "想"},
+				{Type: "message", Text: "答"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			b := newResponsesBuilder(map[string]interface{}{}, "gpt-test")
+			if err := b.stream(w, chunkSource(tt.chunks, nil)); err != nil {
+				t.Fatalf("stream: %v", err)
+			}
+			events := recordedEvents(t, w.Body.String())
+			if got := replayResponses(t, events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("输出项 = %+v, want %+v", got, tt.want)
+			}
+
+			last := events[len(events)-1]
+			if last["type"] != "response.completed" {
+				t.Fatalf("最后的事件 = %v", last["type"])
+			}
+			output, _ := last["response"].(map[string]interface{})["output"].([]interface{})
+			if len(output) != len(tt.want) {
+				t.Fatalf("response.output 有%d项, want %d", len(output), len(tt.want))
+			}
+			for i, raw := range output {
+				if typ := raw.(map[string]interface{})["type"]; typ != tt.want[i].Type {
+					t.Errorf("response.output[%d].type = %v, want %s", i, typ, tt.want[i].Type)
+				}
+			}
+		})
+	}
+}
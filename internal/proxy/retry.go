@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+// statusClientClosedRequest 客户端在响应返回前断开连接（沿用nginx的499）
+const statusClientClosedRequest = 499
+
+// 重试默认参数
+const (
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 10 * time.Second
+	defaultRetryJitter    = 0.2
+)
+
+// defaultRetryStatusCodes 未配置retry.status_codes时重试的状态码
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy 返回后端生效的重试策略，未启用时返回nil
+func (h *Handler) retryPolicy(backend *models.API) *models.RetryPolicy {
+	policy := backend.Retry
+	if policy == nil {
+		policy = h.config.Retry
+	}
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return nil
+	}
+	return policy
+}
+
+// sendWithRetry 向同一个后端发送请求，连接失败或返回可重试的状态码时按退避策略重试
+// 重试只发生在响应返回给客户端之前，因此不会在已向客户端输出内容后重试；客户端断开时立即停止
+func (h *Handler) sendWithRetry(r *http.Request, backend *models.API, reqJSON map[string]interface{}) (*upstreamResponse, error) {
+	policy := h.retryPolicy(backend)
+	for attempt := 1; ; attempt++ {
+		upstream, err := h.sendToBackend(r, backend, copyMap(reqJSON))
+		if policy == nil || attempt >= policy.MaxAttempts {
+			return upstream, err
+		}
+
+		var delay time.Duration
+		if err != nil {
+			if pe, ok := err.(*proxyError); !ok || pe.status != http.StatusServiceUnavailable {
+				return nil, err
+			}
+			delay = backoffDelay(policy, attempt)
+		} else {
+			if !isRetryStatus(policy, upstream.resp.StatusCode) {
+				return upstream, nil
+			}
+			delay = backoffDelay(policy, attempt)
+			if hint, ok := retryAfterDelay(upstream.resp.Header, time.Now()); ok {
+				// 后端要求的等待时间超过上限时不再重试，交给故障转移处理
+				if hint > retryMaxDelay(policy) {
+					return upstream, nil
+				}
+				delay = hint
+			}
+			io.Copy(io.Discard, upstream.resp.Body)
+			upstream.resp.Body.Close()
+		}
+
+		if h.logger != nil {
+			reason := fmt.Sprintf("%v", err)
+			if err == nil {
+				reason = upstream.resp.Status
+			}
+			h.logger.Info("后端 %s 请求失败（%s），%v 后进行第%d/%d次尝试", backend.Name, reason, delay, attempt+1, policy.MaxAttempts)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, &proxyError{status: statusClientClosedRequest, message: "客户端已断开连接"}
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryStatus 判断状态码是否可以重试
+func isRetryStatus(policy *models.RetryPolicy, statusCode int) bool {
+	codes := defaultRetryStatusCodes
+	if len(policy.StatusCodes) > 0 {
+		codes = policy.StatusCodes
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryMaxDelay 返回单次等待的上限
+func retryMaxDelay(policy *models.RetryPolicy) time.Duration {
+	if policy.MaxDelay > 0 {
+		return policy.MaxDelay
+	}
+	return defaultRetryMaxDelay
+}
+
+// backoffDelay 计算第attempt次失败后的指数退避时间，并按jitter随机缩短
+func backoffDelay(policy *models.RetryPolicy, attempt int) time.Duration {
+	base := policy.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	maxDelay := retryMaxDelay(policy)
+
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	// 只在未配置时使用默认值，jitter: 0 表示固定等待
+	jitter := defaultRetryJitter
+	if policy.Jitter != nil {
+		jitter = *policy.Jitter
+	}
+	return delay - time.Duration(rand.Float64()*jitter*float64(delay))
+}
+
+// retryAfterDelay 从Retry-After或x-ratelimit-reset-*响应头读取后端要求的等待时间
+// 存在多个x-ratelimit-reset-*时取最长的一个
+func retryAfterDelay(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return nonNegative(at.Sub(now)), true
+		}
+	}
+
+	var delay time.Duration
+	found := false
+	for key, values := range header {
+		if !strings.HasPrefix(strings.ToLower(key), "x-ratelimit-reset") || len(values) == 0 {
+			continue
+		}
+		if d, ok := parseRateLimitReset(strings.TrimSpace(values[0]), now); ok {
+			found = true
+			if d > delay {
+				delay = d
+			}
+		}
+	}
+	return delay, found
+}
+
+// parseRateLimitReset 解析x-ratelimit-reset-*的值
+// 支持 "1s"/"6m0s" 形式的时长、秒数以及Unix时间戳
+func parseRateLimitReset(value string, now time.Time) (time.Duration, bool) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return nonNegative(d), true
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	// 大于10亿视为Unix时间戳
+	if seconds > 1e9 {
+		at := time.Unix(0, int64(seconds*float64(time.Second)))
+		return nonNegative(at.Sub(now)), true
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
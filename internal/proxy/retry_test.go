@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+		wantOK  bool
+	}{
+		{
+			name:    "Retry-After秒数",
+			headers: map[string]string{"Retry-After": "2"},
+			want:    2 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "Retry-After小数秒",
+			headers: map[string]string{"Retry-After": "0.5"},
+			want:    500 * time.Millisecond,
+			wantOK:  true,
+		},
+		{
+			name:    "Retry-After HTTP日期",
+			headers: map[string]string{"Retry-After": now.Add(90 * time.Second).Format(http.TimeFormat)},
+			want:    90 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "Retry-After已过去的日期",
+			headers: map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)},
+			want:    0,
+			wantOK:  true,
+		},
+		{
+			name:    "Retry-After优先于x-ratelimit-reset",
+			headers: map[string]string{"Retry-After": "1", "X-Ratelimit-Reset-Requests": "10s"},
+			want:    time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "Retry-After无效时读取x-ratelimit-reset",
+			headers: map[string]string{"Retry-After": "soon", "X-Ratelimit-Reset-Tokens": "3s"},
+			want:    3 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "多个x-ratelimit-reset取最长",
+			headers: map[string]string{"X-Ratelimit-Reset-Requests": "1m30s", "X-Ratelimit-Reset-Tokens": "250ms"},
+			want:    90 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "没有相关响应头",
+			headers: map[string]string{"Content-Type": "application/json"},
+			wantOK:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.headers {
+				header.Set(k, v)
+			}
+			got, ok := retryAfterDelay(header, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("retryAfterDelay() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"1s", time.Second, true},
+		{"6m0s", 6 * time.Minute, true},
+		{"1m30s", 90 * time.Second, true},
+		{"20ms", 20 * time.Millisecond, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"0", 0, true},
+		{"-5s", 0, true},
+		{"-1", 0, false},
+		{"", 0, false},
+		{"tomorrow", 0, false},
+		{"1792152030", 30 * time.Second, true},
+		{"1792151970", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseRateLimitReset(tt.value, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseRateLimitReset(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	zero, half := 0.0, 0.5
+	tests := []struct {
+		name     string
+		policy   models.RetryPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		{
+			name:    "jitter为0时固定等待",
+			policy:  models.RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: &zero},
+			attempt: 3,
+			min:     400 * time.Millisecond,
+			max:     400 * time.Millisecond,
+		},
+		{
+			name:    "未设置jitter时按默认比例缩短",
+			policy:  models.RetryPolicy{BaseDelay: 100 * time.Millisecond},
+			attempt: 1,
+			min:     80 * time.Millisecond,
+			max:     100 * time.Millisecond,
+		},
+		{
+			name:    "自定义jitter",
+			policy:  models.RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: &half},
+			attempt: 2,
+			min:     100 * time.Millisecond,
+			max:     200 * time.Millisecond,
+		},
+		{
+			name:    "不超过max_delay",
+			policy:  models.RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second, Jitter: &zero},
+			attempt: 5,
+			min:     3 * time.Second,
+			max:     3 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := backoffDelay(&tt.policy, tt.attempt); got < tt.min || got > tt.max {
+					t.Fatalf("backoffDelay = %v, want [%v, %v]", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
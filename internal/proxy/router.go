@@ -1,29 +1,251 @@
 package proxy
 
-import "trae-proxy-go/pkg/models"
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
 
-// selectBackendByModel 根据请求的模型ID选择后端API
-func selectBackendByModel(config *models.Config, requestedModel string) *models.API {
-	apis := config.APIs
+// router 根据请求选择后端
+// 匹配顺序: 带when条件的routes规则 -> 后端的custom_model_id精确匹配 -> 其余routes规则 -> default_backend -> 第一个激活的后端
+// strict 模式下不回退到默认后端；配置了default_backend时不再回退到第一个激活的后端
+// 每一组规则按配置顺序匹配，第一条命中的规则生效
+type router struct {
+	config *models.Config
+	routes []compiledRoute
+}
 
-	// 首先尝试根据模型ID精确匹配
-	for i := range apis {
-		if apis[i].Active && apis[i].CustomModelID == requestedModel {
-			return &apis[i]
+// compiledRoute 预编译的路由规则
+type compiledRoute struct {
+	route   models.Route
+	pattern *regexp.Regexp
+}
+
+func newRouter(cfg *models.Config) *router {
+	rt := &router{config: cfg}
+	for _, route := range cfg.Routes {
+		// 配置加载时已验证，编译失败的规则直接忽略
+		pattern, err := config.RoutePattern(route)
+		if err != nil {
+			continue
 		}
+		rt.routes = append(rt.routes, compiledRoute{route: route, pattern: pattern})
 	}
+	return rt
+}
 
-	// 如果没有精确匹配，使用第一个激活的API
-	for i := range apis {
-		if apis[i].Active {
-			return &apis[i]
+// requestFeatures 路由条件使用的请求特征
+type requestFeatures struct {
+	hasImages    bool
+	hasTools     bool
+	stream       bool
+	promptTokens int
+	header       http.Header
+}
+
+// extractFeatures 从chat/completions请求中提取路由特征
+func extractFeatures(header http.Header, reqJSON map[string]interface{}) *requestFeatures {
+	f := &requestFeatures{header: header, promptTokens: estimatePromptTokens(reqJSON)}
+	f.stream, _ = reqJSON["stream"].(bool)
+	if tools, ok := reqJSON["tools"].([]interface{}); ok && len(tools) > 0 {
+		f.hasTools = true
+	}
+	if functions, ok := reqJSON["functions"].([]interface{}); ok && len(functions) > 0 {
+		f.hasTools = true
+	}
+
+	messages, _ := reqJSON["messages"].([]interface{})
+	for _, raw := range messages {
+		message, _ := raw.(map[string]interface{})
+		parts, _ := message["content"].([]interface{})
+		for _, rawPart := range parts {
+			if part, ok := rawPart.(map[string]interface{}); ok && part["type"] == "image_url" {
+				f.hasImages = true
+			}
+		}
+	}
+	return f
+}
+
+// satisfies 判断请求是否满足规则的when条件
+func (c *compiledRoute) satisfies(f *requestFeatures) bool {
+	when := c.route.When
+	if when.HasImages != nil && *when.HasImages != f.hasImages {
+		return false
+	}
+	if when.HasTools != nil && *when.HasTools != f.hasTools {
+		return false
+	}
+	if when.Stream != nil && *when.Stream != f.stream {
+		return false
+	}
+	if when.MinPromptTokens > 0 && f.promptTokens < when.MinPromptTokens {
+		return false
+	}
+	if when.MaxPromptTokens > 0 && f.promptTokens > when.MaxPromptTokens {
+		return false
+	}
+	for name, value := range when.Headers {
+		if f.header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// hasModelMatcher 规则是否设置了模型名匹配
+func (c *compiledRoute) hasModelMatcher() bool {
+	return c.route.Model != "" || c.pattern != nil || len(c.route.Aliases) > 0
+}
+
+// matches 判断模型名是否命中规则，只有when条件的规则匹配任意模型名
+func (c *compiledRoute) matches(model string) bool {
+	if !c.hasModelMatcher() {
+		return true
+	}
+	switch {
+	case c.route.Model != "":
+		return c.route.Model == model
+	case c.pattern != nil:
+		return c.pattern.MatchString(model)
+	}
+	for _, alias := range c.route.Aliases {
+		if alias == model {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCandidates 返回候选后端以及返回给客户端的模型ID
+// 多个激活的后端共用同一个自定义模型ID时按priority从小到大排序，用于故障转移
+func (rt *router) selectCandidates(header http.Header, reqJSON map[string]interface{}) ([]*models.API, string) {
+	requestedModel, _ := reqJSON["model"].(string)
+
+	// 首先按请求内容条件匹配，请求特征只在存在条件规则时提取
+	var features *requestFeatures
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		if route.route.When == nil {
+			continue
+		}
+		if features == nil {
+			features = extractFeatures(header, reqJSON)
+		}
+		if route.satisfies(features) && route.matches(requestedModel) {
+			if candidates := rt.routeCandidates(route); len(candidates) > 0 {
+				return candidates, requestedModel
+			}
 		}
 	}
 
-	// 如果都没有激活的，使用第一个
-	if len(apis) > 0 {
-		return &apis[0]
+	// 然后尝试根据模型ID精确匹配
+	if candidates := rt.modelGroup(requestedModel); len(candidates) > 0 {
+		return candidates, requestedModel
 	}
 
+	// 按顺序匹配路由规则，命中后使用目标后端所在的模型组，并向客户端返回请求中的模型名
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		if route.route.When != nil || !route.matches(requestedModel) {
+			continue
+		}
+		if candidates := rt.routeCandidates(route); len(candidates) > 0 {
+			return candidates, requestedModel
+		}
+	}
+
+	// 严格模式下不回退，由调用方返回model_not_found
+	if rt.config.Strict {
+		return nil, ""
+	}
+
+	// 配置了默认后端时使用其所在的模型组；组内没有激活的后端时不回退，由调用方返回明确的错误
+	if rt.config.DefaultBackend != "" {
+		target := rt.backendByName(rt.config.DefaultBackend)
+		if target == nil {
+			return nil, ""
+		}
+		candidates := rt.modelGroup(target.CustomModelID)
+		if len(candidates) == 0 {
+			return nil, ""
+		}
+		return candidates, target.CustomModelID
+	}
+
+	// 否则使用第一个激活的API，未激活的后端永远不会被使用
+	for i := range rt.config.APIs {
+		if rt.config.APIs[i].Active {
+			return []*models.API{&rt.config.APIs[i]}, rt.config.APIs[i].CustomModelID
+		}
+	}
+
+	return nil, ""
+}
+
+// routeCandidates 返回规则目标后端所在模型组中的激活后端
+func (rt *router) routeCandidates(route *compiledRoute) []*models.API {
+	target := rt.backendByName(route.route.Backend)
+	if target == nil {
+		return nil
+	}
+	return rt.modelGroup(target.CustomModelID)
+}
+
+// modelGroup 返回共用该自定义模型ID的激活后端，按priority排序
+func (rt *router) modelGroup(customModelID string) []*models.API {
+	apis := rt.config.APIs
+	var candidates []*models.API
+	for i := range apis {
+		if apis[i].Active && apis[i].CustomModelID == customModelID {
+			candidates = append(candidates, &apis[i])
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority < candidates[j].Priority
+	})
+	return candidates
+}
+
+// backendByName 按名称查找后端
+func (rt *router) backendByName(name string) *models.API {
+	for i := range rt.config.APIs {
+		if rt.config.APIs[i].Name == name {
+			return &rt.config.APIs[i]
+		}
+	}
 	return nil
 }
+
+// modelIDs 返回可用的模型ID: 激活后端的custom_model_id，以及目标可用的无条件路由规则中的精确模型名和别名
+func (rt *router) modelIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
+	add := func(id string) {
+		// 多个后端共用同一个自定义模型ID时只列出一次
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, api := range rt.config.APIs {
+		if api.Active {
+			add(api.CustomModelID)
+		}
+	}
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		// 带条件的规则只在满足条件时生效，不作为独立模型列出
+		if route.route.When != nil || len(rt.routeCandidates(route)) == 0 {
+			continue
+		}
+		add(route.route.Model)
+		for _, alias := range route.route.Aliases {
+			add(alias)
+		}
+	}
+	return ids
+}
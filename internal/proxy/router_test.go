@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"trae-proxy-go/pkg/models"
+)
+
+// routerAPIs 路由测试使用的后端，deepseek和deepseek-backup共用同一个自定义模型ID
+func routerAPIs() []models.API {
+	return []models.API{
+		{Name: "deepseek", CustomModelID: "deepseek-chat", Active: true, Priority: 2},
+		{Name: "deepseek-backup", CustomModelID: "deepseek-chat", Active: true, Priority: 1},
+		{Name: "moonshot", CustomModelID: "kimi", Active: true},
+		{Name: "vision", CustomModelID: "vision", Active: true},
+		{Name: "off", CustomModelID: "off-model", Active: false},
+	}
+}
+
+// candidateNames 返回候选后端的名称
+func candidateNames(candidates []*models.API) []string {
+	var names []string
+	for _, api := range candidates {
+		names = append(names, api.Name)
+	}
+	return names
+}
+
+func TestRouterSelectCandidates(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name           string
+		routes         []models.Route
+		defaultBackend string
+		strict         bool
+		model          string
+		wantBackends   []string
+		wantModel      string
+	}{
+		{
+			name:         "精确匹配custom_model_id并按priority排序",
+			model:        "deepseek-chat",
+			wantBackends: []string{"deepseek-backup", "deepseek"},
+			wantModel:    "deepseek-chat",
+		},
+		{
+			name:         "精确匹配优先于无条件规则",
+			routes:       []models.Route{{Glob: "*", Backend: "moonshot"}},
+			model:        "deepseek-chat",
+			wantBackends: []string{"deepseek-backup", "deepseek"},
+			wantModel:    "deepseek-chat",
+		},
+		{
+			name:         "条件规则优先于精确匹配",
+			routes:       []models.Route{{When: &models.RouteCondition{Stream: &yes}, Backend: "moonshot"}},
+			model:        "deepseek-chat",
+			wantBackends: []string{"moonshot"},
+			wantModel:    "deepseek-chat",
+		},
+		{
+			name: "无条件规则按配置顺序匹配并返回请求的模型名",
+			routes: []models.Route{
+				{Model: "gpt-4o", Backend: "moonshot"},
+				{Glob: "gpt-*", Backend: "deepseek"},
+			},
+			model:        "gpt-4o",
+			wantBackends: []string{"moonshot"},
+			wantModel:    "gpt-4o",
+		},
+		{
+			name:         "规则目标后端未激活时继续匹配",
+			routes:       []models.Route{{Glob: "gpt-*", Backend: "off"}, {Glob: "gpt-*", Backend: "moonshot"}},
+			model:        "gpt-4o",
+			wantBackends: []string{"moonshot"},
+			wantModel:    "gpt-4o",
+		},
+		{
+			name:         "未激活的后端不能精确匹配",
+			model:        "off-model",
+			wantBackends: []string{"deepseek"},
+			wantModel:    "deepseek-chat",
+		},
+		{
+			name:         "严格模式不回退",
+			strict:       true,
+			model:        "unknown",
+			wantBackends: nil,
+			wantModel:    "",
+		},
+		{
+			name:           "严格模式优先于default_backend",
+			defaultBackend: "moonshot",
+			strict:         true,
+			model:          "unknown",
+			wantBackends:   nil,
+			wantModel:      "",
+		},
+		{
+			name:           "未匹配时使用default_backend所在的模型组",
+			defaultBackend: "deepseek",
+			model:          "unknown",
+			wantBackends:   []string{"deepseek-backup", "deepseek"},
+			wantModel:      "deepseek-chat",
+		},
+		{
+			name:           "default_backend未激活时不回退到第一个激活的后端",
+			defaultBackend: "off",
+			model:          "unknown",
+			wantBackends:   nil,
+			wantModel:      "",
+		},
+		{
+			name:         "未配置default_backend时使用第一个激活的后端",
+			model:        "unknown",
+			wantBackends: []string{"deepseek"},
+			wantModel:    "deepseek-chat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{APIs: routerAPIs(), Routes: tt.routes, DefaultBackend: tt.defaultBackend, Strict: tt.strict}
+			req := chatRequest(tt.model)
+			req["stream"] = true
+			candidates, model := newRouter(cfg).selectCandidates(http.Header{}, req)
+			if names := candidateNames(candidates); !reflect.DeepEqual(names, tt.wantBackends) {
+				t.Errorf("候选后端 = %v, want %v", names, tt.wantBackends)
+			}
+			if model != tt.wantModel {
+				t.Errorf("模型 = %q, want %q", model, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		route models.Route
+		model string
+		want  bool
+	}{
+		{"glob匹配前缀", models.Route{Glob: "gpt-4*"}, "gpt-4o-mini", true},
+		{"glob匹配完整名称", models.Route{Glob: "gpt-4*"}, "my-gpt-4o", false},
+		{"glob的?匹配单个字符", models.Route{Glob: "o?-mini"}, "o3-mini", true},
+		{"glob的?不匹配多个字符", models.Route{Glob: "o?-mini"}, "o10-mini", false},
+		{"glob的*匹配/", models.Route{Glob: "openai/*"}, "openai/gpt-4o", true},
+		{"glob中的.按字面匹配", models.Route{Glob: "qwen2.5*"}, "qwen2x5-max", false},
+		{"regex匹配完整名称", models.Route{Regex: "claude-(3|4).*"}, "claude-3-opus", true},
+		{"regex不做部分匹配", models.Route{Regex: "claude"}, "claude-3-opus", false},
+		{"regex的分支整体锚定", models.Route{Regex: "a|b"}, "ab", false},
+		{"regex显式锚定仍然有效", models.Route{Regex: "^claude-.*$"}, "claude-3", true},
+		{"精确匹配", models.Route{Model: "gpt-4o"}, "gpt-4o", true},
+		{"精确匹配不匹配前缀", models.Route{Model: "gpt-4o"}, "gpt-4o-mini", false},
+		{"别名", models.Route{Aliases: []string{"gpt-4o", "gpt-4o-mini"}}, "gpt-4o-mini", true},
+		{"不在别名列表中", models.Route{Aliases: []string{"gpt-4o"}}, "gpt-4", false},
+		{"只有when条件时匹配任意模型名", models.Route{When: &models.RouteCondition{}}, "anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.Backend = "moonshot"
+			rt := newRouter(&models.Config{APIs: routerAPIs(), Routes: []models.Route{tt.route}})
+			if len(rt.routes) != 1 {
+				t.Fatalf("规则编译失败")
+			}
+			if got := rt.routes[0].matches(tt.model); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteConditions(t *testing.T) {
+	yes, no := true, false
+	image := map[string]interface{}{
+		"role": "user",
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "这是什么"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,AAAA"}},
+		},
+	}
+	long := map[string]interface{}{"role": "user", "content": strings.Repeat("长文本", 2000)}
+	tools := []interface{}{map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "read"}}}
+
+	tests := []struct {
+		name     string
+		when     models.RouteCondition
+		messages []interface{}
+		tools    []interface{}
+		stream   bool
+		header   http.Header
+		want     bool
+	}{
+		{"包含图片", models.RouteCondition{HasImages: &yes}, []interface{}{image}, nil, false, nil, true},
+		{"要求包含图片但没有", models.RouteCondition{HasImages: &yes}, nil, nil, false, nil, false},
+		{"要求不含图片", models.RouteCondition{HasImages: &no}, []interface{}{image}, nil, false, nil, false},
+		{"带有tools", models.RouteCondition{HasTools: &yes}, nil, tools, false, nil, true},
+		{"要求没有tools", models.RouteCondition{HasTools: &no}, nil, tools, false, nil, false},
+		{"流式请求", models.RouteCondition{Stream: &yes}, nil, nil, true, nil, true},
+		{"要求非流式", models.RouteCondition{Stream: &no}, nil, nil, true, nil, false},
+		{"输入token数达到下限", models.RouteCondition{MinPromptTokens: 1000}, []interface{}{long}, nil, false, nil, true},
+		{"输入token数不足下限", models.RouteCondition{MinPromptTokens: 1000}, nil, nil, false, nil, false},
+		{"输入token数超过上限", models.RouteCondition{MaxPromptTokens: 1000}, []interface{}{long}, nil, false, nil, false},
+		{"请求头相同", models.RouteCondition{Headers: map[string]string{"X-Client-Name": "trae"}}, nil, nil, false, http.Header{"X-Client-Name": {"trae"}}, true},
+		{"请求头不同", models.RouteCondition{Headers: map[string]string{"X-Client-Name": "trae"}}, nil, nil, false, http.Header{"X-Client-Name": {"cursor"}}, false},
+		{"所有条件都满足", models.RouteCondition{HasTools: &yes, Stream: &yes}, nil, tools, true, nil, true},
+		{"部分条件不满足", models.RouteCondition{HasTools: &yes, Stream: &yes}, nil, tools, false, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			when := tt.when
+			cfg := &models.Config{APIs: routerAPIs(), Routes: []models.Route{{When: &when, Backend: "vision"}}}
+			req := chatRequest("deepseek-chat")
+			if tt.messages != nil {
+				req["messages"] = tt.messages
+			}
+			if tt.tools != nil {
+				req["tools"] = tt.tools
+			}
+			req["stream"] = tt.stream
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+
+			candidates, _ := newRouter(cfg).selectCandidates(header, req)
+			if got := len(candidates) == 1 && candidates[0].Name == "vision"; got != tt.want {
+				t.Errorf("命中条件规则 = %v, want %v（候选后端 %v）", got, tt.want, candidateNames(candidates))
+			}
+		})
+	}
+}
+
+func TestRouterModelIDs(t *testing.T) {
+	cfg := &models.Config{
+		APIs: routerAPIs(),
+		Routes: []models.Route{
+			{Aliases: []string{"gpt-4o", "gpt-4o-mini"}, Backend: "deepseek"},
+			{Model: "claude", Backend: "moonshot"},
+			{Model: "hidden", Backend: "off"},
+			{Glob: "gpt-*", Backend: "moonshot"},
+			{Model: "conditional", When: &models.RouteCondition{}, Backend: "vision"},
+		},
+	}
+	want := []string{"deepseek-chat", "kimi", "vision", "gpt-4o", "gpt-4o-mini", "claude"}
+	if got := newRouter(cfg).modelIDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("modelIDs = %v, want %v", got, want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"trae-proxy-go/internal/config"
 	"trae-proxy-go/internal/logger"
 	"trae-proxy-go/pkg/models"
 )
@@ -39,6 +40,48 @@ func NewServer(config *models.Config, logger *logger.Logger, certFile, keyFile s
 	}, nil
 }
 
+// SetBreakerStateFile 设置熔断器状态文件路径，供CLI查看各后端的熔断状态
+func (s *Server) SetBreakerStateFile(path string) {
+	s.handler.breakers.setStatePath(path)
+}
+
+// SetUsageLedger 打开用量账本，每个请求的用量和费用追加到该文件，供CLI统计
+func (s *Server) SetUsageLedger(path string) error {
+	ledger, err := config.OpenUsageLedger(path, func(err error) {
+		if s.logger != nil {
+			s.logger.Error("%v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	s.handler.ledger = ledger
+	return nil
+}
+
+// SetTokenizer 加载tiktoken格式的cl100k_base词表，用于后端未返回用量和上下文窗口等场景的本地token估算
+// 未加载时按字符数启发式估算
+func (s *Server) SetTokenizer(path string) error {
+	tokenizer, err := loadBPETokenizer(path)
+	if err != nil {
+		return err
+	}
+	textTokenizer.Store(tokenizer)
+	if s.logger != nil {
+		s.logger.Info("已加载BPE词表 %s（%d 个token），本地token估算使用 %s", path, len(tokenizer.ranks), tokenizer.name)
+	}
+	return nil
+}
+
+// Close 输出最终的请求统计并关闭服务器持有的资源，用量账本中尚未写入的记录会同步到磁盘
+func (s *Server) Close() error {
+	s.handler.logStats()
+	if s.handler.ledger != nil {
+		return s.handler.ledger.Close()
+	}
+	return nil
+}
+
 // Start 启动服务器
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -47,7 +90,10 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/", s.handler.HandleRoot)
 	mux.HandleFunc("/v1", s.handler.HandleV1Root)
 	mux.HandleFunc("/v1/models", s.handler.HandleModels)
-	mux.HandleFunc("/v1/chat/completions", s.handler.HandleChatCompletions)
+	mux.HandleFunc("/v1/chat/completions", s.handler.trackRequest(s.handler.HandleChatCompletions))
+	mux.HandleFunc("/v1/messages", s.handler.trackRequest(s.handler.HandleMessages))
+	mux.HandleFunc("/v1/responses", s.handler.trackRequest(s.handler.HandleResponses))
+	mux.HandleFunc("/v1/responses/", s.handler.HandleResponseByID)
 
 	server := &http.Server{
 		Addr:      fmt.Sprintf(":%d", s.config.Server.Port),
@@ -65,10 +111,15 @@ func (s *Server) Start() error {
 					status = "未激活"
 				}
 				s.logger.Info("  - %s [%s]: %s -> %s", api.Name, status, api.Endpoint, api.CustomModelID)
+				if _, err := config.ResolveAPIKey(api.APIKey); err != nil {
+					s.logger.Error("    后端 %s 的密钥不可用: %v", api.Name, err)
+				}
 			}
 		}
 	}
 
+	go s.handler.reportStats()
+
 	if s.tlsConfig != nil {
 		// 当使用TLSConfig时，certFile和keyFile可以为空，证书从TLSConfig中获取
 		return server.ListenAndServeTLS("", "")
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errStreamDone 读取到[DONE]时用于提前结束读取
+var errStreamDone = errors.New("stream done")
+
+// sseEvent 一个完整的SSE事件
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// readSSEEvents 逐个读取SSE事件，事件以空行结束
+// 按行读取，跨多次Read拆分的行和多字节字符会被完整拼接
+func readSSEEvents(r io.Reader, fn func(ev sseEvent) error) error {
+	reader := bufio.NewReader(r)
+	var ev sseEvent
+	var dataLines []string
+
+	dispatch := func() error {
+		if len(dataLines) == 0 && ev.Event == "" {
+			return nil
+		}
+		ev.Data = strings.Join(dataLines, "\n")
+		err := fn(ev)
+		ev = sseEvent{}
+		dataLines = dataLines[:0]
+		return err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "":
+				if dErr := dispatch(); dErr != nil {
+					return dErr
+				}
+			case strings.HasPrefix(line, ":"):
+				// 注释行
+			case strings.HasPrefix(line, "data:"):
+				dataLines = append(dataLines, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			case strings.HasPrefix(line, "event:"):
+				ev.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			}
+		}
+		if err == io.EOF {
+			return dispatch()
+		}
+		if err != nil {
+			return fmt.Errorf("读取流数据失败: %w", err)
+		}
+	}
+}
+
+// sseWriter 向客户端写入SSE事件
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("响应写入器不支持刷新")
+	}
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// start 写入SSE响应头，只执行一次
+func (s *sseWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+}
+
+// writeEvent 写入一个事件，event为空时只写data行
+func (s *sseWriter) writeEvent(event string, data interface{}) error {
+	s.start()
+	// 不转义HTML字符，保持<think>等标签原样输出
+	var jsonData bytes.Buffer
+	enc := json.NewEncoder(&jsonData)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(data); err != nil {
+		return fmt.Errorf("序列化事件失败: %w", err)
+	}
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: ")
+		b.WriteString(event)
+		b.WriteString("\n")
+	}
+	b.WriteString("data: ")
+	b.Write(bytes.TrimRight(jsonData.Bytes(), "\n"))
+	b.WriteString("\n\n")
+	if _, err := io.WriteString(s.w, b.String()); err != nil {
+		return fmt.Errorf("写入响应失败: %w", err)
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// writeDone 写入OpenAI风格的结束标记
+func (s *sseWriter) writeDone() error {
+	s.start()
+	if _, err := io.WriteString(s.w, "data: [DONE]\n\n"); err != nil {
+		return fmt.Errorf("写入结束标记失败: %w", err)
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// readChatChunks 读取OpenAI chat.completion.chunk流，遇到[DONE]结束
+func readChatChunks(r io.Reader, fn func(chunk map[string]interface{}) error) error {
+	err := readSSEEvents(r, func(ev sseEvent) error {
+		data := strings.TrimSpace(ev.Data)
+		if data == "" {
+			return nil
+		}
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			// 非JSON数据忽略
+			return nil
+		}
+		return fn(chunk)
+	})
+	if err == errStreamDone {
+		return nil
+	}
+	return err
+}
@@ -1,16 +1,23 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
+	"trae-proxy-go/pkg/models"
 )
 
 // StreamResponse 处理流式响应转发
-// 注意: customModelID 参数保留以保持接口一致性，但在流式响应中不进行模型ID替换
-// 因为流式响应是逐块发送的，替换模型ID需要复杂的JSON解析，且Python版本也未实现
-func StreamResponse(w http.ResponseWriter, r io.Reader, customModelID string) error {
+// 按行转发SSE数据，data事件中的model字段替换为customModelID；
+// 非JSON数据、[DONE]和注释行原样转发
+// 客户端断开时ctx被取消，后端请求随之中止，不再继续读取
+func StreamResponse(ctx context.Context, w http.ResponseWriter, r io.Reader, customModelID string) error {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		return fmt.Errorf("响应写入器不支持刷新")
@@ -20,135 +27,239 @@ func StreamResponse(w http.ResponseWriter, r io.Reader, customModelID string) er
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	// 直接转发流式数据
-	buf := make([]byte, 4096)
+	// 按行读取，跨多次Read拆分的行和多字节字符会被完整拼接后再处理
+	reader := bufio.NewReader(r)
 	for {
-		n, err := r.Read(buf)
-		if n > 0 {
-			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if _, writeErr := io.WriteString(w, rewriteSSEModel(line, customModelID)); writeErr != nil {
 				return fmt.Errorf("写入响应失败: %w", writeErr)
 			}
-			flusher.Flush()
+			// 已缓冲的数据处理完后再刷新，避免每行都触发一次写出
+			if reader.Buffered() == 0 {
+				flusher.Flush()
+			}
 		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
 			return fmt.Errorf("读取流数据失败: %w", err)
 		}
 	}
+	flusher.Flush()
 
 	return nil
 }
 
-// SimulateStream 将非流式响应模拟为流式响应
-func SimulateStream(w http.ResponseWriter, responseJSON map[string]interface{}, customModelID string) error {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		return fmt.Errorf("响应写入器不支持刷新")
+// rewriteSSEModel 替换单行SSE data中JSON对象的model字段，其他内容保持不变
+func rewriteSSEModel(line string, customModelID string) string {
+	if customModelID == "" || !strings.HasPrefix(line, "data:") {
+		return line
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	content := strings.TrimRight(line, "\r\n")
+	ending := line[len(content):]
+	payload := strings.TrimPrefix(content, "data:")
+	prefix := "data:"
+	if strings.HasPrefix(payload, " ") {
+		prefix = "data: "
+		payload = payload[1:]
+	}
+	if !strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		return line
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return line
+	}
+	if _, ok := fields["model"]; !ok {
+		return line
+	}
+	model, _ := json.Marshal(customModelID)
+	fields["model"] = model
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return line
+	}
+	return prefix + strings.TrimRight(buf.String(), "\n") + ending
+}
+
+// defaultSimulateChunkSize 模拟流式输出时每块的默认字符数
+const defaultSimulateChunkSize = 4
+
+// SimulateStream 将非流式响应模拟为流式响应
+// 支持多个choice、tool_calls、reasoning_content和usage，按字符（rune）切分内容，
+// 保留原响应的id和created；opts为nil时使用默认块大小且不等待
+func SimulateStream(ctx context.Context, w http.ResponseWriter, responseJSON map[string]interface{}, customModelID string, opts *models.SimulateConfig) error {
+	out, err := newSSEWriter(w)
+	if err != nil {
+		return err
+	}
 
-	// 提取内容
 	choices, ok := responseJSON["choices"].([]interface{})
 	if !ok || len(choices) == 0 {
 		return fmt.Errorf("无效的响应格式")
 	}
-
-	choice, ok := choices[0].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("无效的选择格式")
+	if customModelID != "" {
+		responseJSON["model"] = customModelID
 	}
 
-	message, ok := choice["message"].(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("无效的消息格式")
+	if err := simulateChunks(ctx, responseJSON, opts, func(chunk map[string]interface{}) error {
+		return out.writeEvent("", chunk)
+	}); err != nil {
+		return err
 	}
+	return out.writeDone()
+}
 
-	content, ok := message["content"].(string)
-	if !ok {
-		return fmt.Errorf("无效的内容格式")
-	}
-
-	// 发送初始块
-	initialChunk := map[string]interface{}{
-		"id":      "chatcmpl-simulated",
-		"object":  "chat.completion.chunk",
-		"created": 1,
-		"model":   customModelID,
-		"choices": []map[string]interface{}{
-			{
-				"index":         0,
-				"delta":         map[string]interface{}{"role": "assistant"},
-				"finish_reason": nil,
-			},
-		},
-	}
-	sendSSEChunk(w, initialChunk)
-	flusher.Flush()
+// simulateChunks 将chat.completion对象拆分为chunk序列并依次回调，按配置在块之间等待
+func simulateChunks(ctx context.Context, responseJSON map[string]interface{}, opts *models.SimulateConfig, fn func(chunk map[string]interface{}) error) error {
+	chunkSize := defaultSimulateChunkSize
+	var interval time.Duration
+	if opts != nil {
+		if opts.ChunkSize > 0 {
+			chunkSize = opts.ChunkSize
+		}
+		interval = opts.Interval
+	}
 
-	// 将内容分成多个块发送
-	chunkSize := 4
-	for i := 0; i < len(content); i += chunkSize {
-		end := i + chunkSize
-		if end > len(content) {
-			end = len(content)
+	chunks := chatResponseToChunks(responseJSON, chunkSize)
+	for i, chunk := range chunks {
+		if i > 0 && interval > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err := fn(chunk); err != nil {
+			return err
 		}
-		chunk := content[i:end]
+	}
+	return nil
+}
+
+// chatResponseToChunks 将chat.completion对象拆分为等价的chunk序列
+// content和reasoning_content按chunkSize个字符切分，tool_calls的arguments同样切分；
+// usage附加在最后一个chunk上
+func chatResponseToChunks(resp map[string]interface{}, chunkSize int) []map[string]interface{} {
+	id := resp["id"]
+	if s, _ := id.(string); s == "" {
+		id = "chatcmpl-simulated"
+	}
+	created := resp["created"]
+	if created == nil {
+		created = time.Now().Unix()
+	}
 
-		chunkData := map[string]interface{}{
-			"id":      "chatcmpl-simulated",
+	newChunk := func(index int, delta map[string]interface{}, finishReason interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      id,
 			"object":  "chat.completion.chunk",
-			"created": 1,
-			"model":   customModelID,
-			"choices": []map[string]interface{}{
-				{
-					"index":         0,
-					"delta":         map[string]interface{}{"content": chunk},
-					"finish_reason": nil,
-				},
-			},
+			"created": created,
+			"model":   resp["model"],
+			"choices": []interface{}{map[string]interface{}{
+				"index":         index,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			}},
+		}
+	}
+
+	var chunks []map[string]interface{}
+	choices, _ := resp["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := intValue(choice["index"])
+		message, _ := choice["message"].(map[string]interface{})
+
+		role, _ := message["role"].(string)
+		if role == "" {
+			role = "assistant"
+		}
+		chunks = append(chunks, newChunk(index, map[string]interface{}{"role": role}, nil))
+
+		for _, key := range []string{"reasoning_content", "content"} {
+			text, _ := message[key].(string)
+			for _, piece := range splitRunes(text, chunkSize) {
+				chunks = append(chunks, newChunk(index, map[string]interface{}{key: piece}, nil))
+			}
+		}
+
+		toolCalls, _ := message["tool_calls"].([]interface{})
+		for i, rawCall := range toolCalls {
+			call, ok := rawCall.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			function, _ := call["function"].(map[string]interface{})
+			callType := call["type"]
+			if callType == nil {
+				callType = "function"
+			}
+			chunks = append(chunks, newChunk(index, map[string]interface{}{
+				"tool_calls": []interface{}{map[string]interface{}{
+					"index":    i,
+					"id":       call["id"],
+					"type":     callType,
+					"function": map[string]interface{}{"name": function["name"], "arguments": ""},
+				}},
+			}, nil))
+			args, _ := function["arguments"].(string)
+			for _, piece := range splitRunes(args, chunkSize) {
+				chunks = append(chunks, newChunk(index, map[string]interface{}{
+					"tool_calls": []interface{}{map[string]interface{}{
+						"index":    i,
+						"function": map[string]interface{}{"arguments": piece},
+					}},
+				}, nil))
+			}
 		}
-		sendSSEChunk(w, chunkData)
-		flusher.Flush()
-	}
-
-	// 发送完成标记
-	finalChunk := map[string]interface{}{
-		"id":      "chatcmpl-simulated",
-		"object":  "chat.completion.chunk",
-		"created": 1,
-		"model":   customModelID,
-		"choices": []map[string]interface{}{
-			{
-				"index":         0,
-				"delta":         map[string]interface{}{},
-				"finish_reason": "stop",
-			},
-		},
-	}
-	sendSSEChunk(w, finalChunk)
-	flusher.Flush()
 
-	// 发送结束标记
-	if _, err := w.Write([]byte("data: [DONE]\n\n")); err != nil {
-		return fmt.Errorf("写入结束标记失败: %w", err)
+		finishReason := choice["finish_reason"]
+		if finishReason == nil {
+			finishReason = "stop"
+		}
+		chunks = append(chunks, newChunk(index, map[string]interface{}{}, finishReason))
 	}
-	flusher.Flush()
 
-	return nil
+	if usage, ok := resp["usage"]; ok && usage != nil && len(chunks) > 0 {
+		chunks[len(chunks)-1]["usage"] = usage
+	}
+	return chunks
 }
 
-// sendSSEChunk 发送SSE格式的数据块
-func sendSSEChunk(w http.ResponseWriter, data map[string]interface{}) {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return
+// splitRunes 按字符数切分字符串，不会拆开多字节字符
+func splitRunes(s string, size int) []string {
+	if s == "" {
+		return nil
+	}
+	if size <= 0 {
+		return []string{s}
+	}
+	var parts []string
+	count := 0
+	start := 0
+	for i := range s {
+		if count == size {
+			parts = append(parts, s[start:i])
+			start = i
+			count = 0
+		}
+		count++
 	}
-	w.Write([]byte("data: "))
-	w.Write(jsonData)
-	w.Write([]byte("\n\n"))
+	return append(parts, s[start:])
 }
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+func TestRewriteSSEModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		model string
+		want  string
+	}{
+		{
+			name:  "替换model",
+			line:  "data: {\"id\":\"1\",\"model\":\"up\"}\n",
+			model: "custom",
+			want:  "data: {\"id\":\"1\",\"model\":\"custom\"}\n",
+		},
+		{
+			name:  "data后没有空格",
+			line:  "data:{\"model\":\"up\"}\n",
+			model: "custom",
+			want:  "data:{\"model\":\"custom\"}\n",
+		},
+		{
+			name:  "保留CRLF行尾",
+			line:  "data: {\"model\":\"up\"}\r\n",
+			model: "custom",
+			want:  "data: {\"model\":\"custom\"}\r\n",
+		},
+		{
+			name:  "最后一行没有换行",
+			line:  "data: {\"model\":\"up\"}",
+			model: "custom",
+			want:  "data: {\"model\":\"custom\"}",
+		},
+		{
+			name:  "不转义HTML字符",
+			line:  "data: {\"content\":\"<think>a & b</think>\",\"model\":\"up\"}\n",
+			model: "custom",
+			want:  "data: {\"content\":\"<think>a & b</think>\",\"model\":\"custom\"}\n",
+		},
+		{
+			name:  "没有model字段",
+			line:  "data: {\"id\":\"1\"}\n",
+			model: "custom",
+			want:  "data: {\"id\":\"1\"}\n",
+		},
+		{
+			name:  "DONE",
+			line:  "data: [DONE]\n",
+			model: "custom",
+			want:  "data: [DONE]\n",
+		},
+		{
+			name:  "无效JSON",
+			line:  "data: {\"model\":\n",
+			model: "custom",
+			want:  "data: {\"model\":\n",
+		},
+		{
+			name:  "注释行",
+			line:  ": keep-alive\n",
+			model: "custom",
+			want:  ": keep-alive\n",
+		},
+		{
+			name:  "event行",
+			line:  "event: message\n",
+			model: "custom",
+			want:  "event: message\n",
+		},
+		{
+			name:  "空行",
+			line:  "\n",
+			model: "custom",
+			want:  "\n",
+		},
+		{
+			name: "未设置自定义模型",
+			line: "data: {\"model\":\"up\"}\n",
+			want: "data: {\"model\":\"up\"}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteSSEModel(tt.line, tt.model); got != tt.want {
+				t.Errorf("rewriteSSEModel(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamResponseSplitReads(t *testing.T) {
+	upstream := "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}],\"model\":\"up\"}\n\n" +
+		": ping\n\n" +
+		"data: {\"choices\":[],\"model\":\"up\"}\r\n\r\n" +
+		"data: [DONE]\n\n"
+	want := "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}],\"model\":\"custom\"}\n\n" +
+		": ping\n\n" +
+		"data: {\"choices\":[],\"model\":\"custom\"}\r\n\r\n" +
+		"data: [DONE]\n\n"
+
+	// 每次只读一个字节，行和多字节字符都会跨多次Read拆分
+	w := httptest.NewRecorder()
+	if err := StreamResponse(context.Background(), w, iotest.OneByteReader(strings.NewReader(upstream)), "custom"); err != nil {
+		t.Fatalf("StreamResponse: %v", err)
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestSplitRunes(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want []string
+	}{
+		{"", 4, nil},
+		{"abcdefg", 3, []string{"abc", "def", "g"}},
+		{"你好世界", 3, []string{"你好世", "界"}},
+		{"abcd", 4, []string{"abcd"}},
+		{"abc", 0, []string{"abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := splitRunes(tt.text, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRunes(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatResponseToChunks(t *testing.T) {
+	usage := map[string]interface{}{"prompt_tokens": 3, "completion_tokens": 7}
+	resp := map[string]interface{}{
+		"id":      "chatcmpl-1",
+		"created": 1700000000,
+		"model":   "custom",
+		"choices": []interface{}{map[string]interface{}{
+			"index": 0,
+			"message": map[string]interface{}{
+				"role":              "assistant",
+				"content":           "你好，世界",
+				"reasoning_content": "想一想",
+				"tool_calls": []interface{}{map[string]interface{}{
+					"id":       "call_1",
+					"function": map[string]interface{}{"name": "read", "arguments": `{"path":"a"}`},
+				}},
+			},
+			"finish_reason": "tool_calls",
+		}},
+		"usage": usage,
+	}
+
+	chunks := chatResponseToChunks(resp, 2)
+	var deltas []string
+	for _, chunk := range chunks {
+		if chunk["id"] != "chatcmpl-1" || chunk["object"] != "chat.completion.chunk" || chunk["model"] != "custom" {
+			t.Fatalf("chunk元数据 = %v", chunk)
+		}
+		choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+		delta := choice["delta"].(map[string]interface{})
+		for _, key := range []string{"reasoning_content", "content"} {
+			if text, ok := delta[key].(string); ok {
+				deltas = append(deltas, text)
+			}
+		}
+		if calls, ok := delta["tool_calls"].([]interface{}); ok {
+			args := calls[0].(map[string]interface{})["function"].(map[string]interface{})["arguments"].(string)
+			deltas = append(deltas, args)
+		}
+	}
+	want := []string{"想一", "想", "你好", "，世", "界", "", `{"`, `pa`, `th`, `":`, `"a`, `"}`}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+
+	last := chunks[len(chunks)-1]
+	if reason := last["choices"].([]interface{})[0].(map[string]interface{})["finish_reason"]; reason != "tool_calls" {
+		t.Errorf("finish_reason = %v", reason)
+	}
+	if !reflect.DeepEqual(last["usage"], usage) {
+		t.Errorf("最后一个chunk的usage = %v", last["usage"])
+	}
+	for _, chunk := range chunks[:len(chunks)-1] {
+		if _, ok := chunk["usage"]; ok {
+			t.Errorf("usage只应出现在最后一个chunk")
+		}
+	}
+
+	// 模拟出的chunk重新合并后与原响应一致
+	agg := newChatAggregator()
+	for _, chunk := range chunks {
+		agg.add(chunk)
+	}
+	message := agg.result()["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	original := resp["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	if message["content"] != original["content"] || message["reasoning_content"] != original["reasoning_content"] {
+		t.Errorf("合并后的message = %v", message)
+	}
+	call := message["tool_calls"].([]interface{})[0].(map[string]interface{})
+	if call["id"] != "call_1" || call["type"] != "function" || call["function"].(map[string]interface{})["arguments"] != `{"path":"a"}` {
+		t.Errorf("合并后的tool_call = %v", call)
+	}
+}
+
+func TestChatResponseToChunksDefaults(t *testing.T) {
+	resp := map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]interface{}{"content": "hi"}}},
+	}
+	chunks := chatResponseToChunks(resp, 0)
+	if len(chunks) != 3 {
+		t.Fatalf("chunks = %v", chunks)
+	}
+	if chunks[0]["id"] != "chatcmpl-simulated" || chunks[0]["created"] == nil {
+		t.Errorf("缺少默认的id或created: %v", chunks[0])
+	}
+	first := chunks[0]["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+	if first["role"] != "assistant" {
+		t.Errorf("第一个chunk的role = %v", first["role"])
+	}
+	if reason := chunks[2]["choices"].([]interface{})[0].(map[string]interface{})["finish_reason"]; reason != "stop" {
+		t.Errorf("默认finish_reason = %v, want stop", reason)
+	}
+}
+
+func TestSimulateStream(t *testing.T) {
+	resp := map[string]interface{}{
+		"id":      "chatcmpl-1",
+		"model":   "upstream",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]interface{}{"role": "assistant", "content": "abcdef"}, "finish_reason": "stop"}},
+	}
+	w := httptest.NewRecorder()
+	if err := SimulateStream(context.Background(), w, resp, "custom", &models.SimulateConfig{ChunkSize: 4}); err != nil {
+		t.Fatalf("SimulateStream: %v", err)
+	}
+
+	body := w.Body.String()
+	if !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("没有以[DONE]结束: %q", body)
+	}
+	agg, err := aggregateChatStream(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("aggregateChatStream: %v", err)
+	}
+	if agg["model"] != "custom" {
+		t.Errorf("model = %v, want custom", agg["model"])
+	}
+	message := agg["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	if message["content"] != "abcdef" {
+		t.Errorf("content = %v", message["content"])
+	}
+	if n := strings.Count(body, "\"content\":"); n != 2 {
+		t.Errorf("content应切分为2块, got %d", n)
+	}
+}
+
+func TestSimulateChunksCanceled(t *testing.T) {
+	resp := map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]interface{}{"content": "abcdefgh"}}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := 0
+	err := simulateChunks(ctx, resp, &models.SimulateConfig{ChunkSize: 1, Interval: time.Hour}, func(chunk map[string]interface{}) error {
+		sent++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || sent != 1 {
+		t.Errorf("simulateChunks = %v，发送了%d块, want context.Canceled 1", err, sent)
+	}
+}
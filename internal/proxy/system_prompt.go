@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+	"trae-proxy-go/pkg/models"
+)
+
+// applySystemPrompts 按后端配置的规则调整请求中的系统消息
+// requestedModel 为客户端请求的模型，用于模板变量{{model}}
+func applySystemPrompts(reqJSON map[string]interface{}, backend *models.API, requestedModel string) {
+	if len(backend.SystemPrompts) == 0 {
+		return
+	}
+	vars := strings.NewReplacer(
+		"{{backend}}", backend.Name,
+		"{{model}}", requestedModel,
+		"{{target_model}}", backend.TargetModelID,
+		"{{date}}", time.Now().Format("2006-01-02"),
+	)
+
+	messages, _ := reqJSON["messages"].([]interface{})
+	for _, rule := range backend.SystemPrompts {
+		messages = applySystemPromptRule(messages, rule.Mode, vars.Replace(rule.Content))
+	}
+	reqJSON["messages"] = messages
+}
+
+// applySystemPromptRule 应用一条规则，返回新的消息列表，原消息不会被修改
+// prepend合并到第一条系统消息开头，append合并到最后一条系统消息末尾，没有系统消息时在开头插入
+func applySystemPromptRule(messages []interface{}, mode, content string) []interface{} {
+	prompt := map[string]interface{}{"role": "system", "content": content}
+
+	if mode == "replace" {
+		out := []interface{}{prompt}
+		for _, raw := range messages {
+			if !isSystemMessage(raw) {
+				out = append(out, raw)
+			}
+		}
+		return out
+	}
+
+	target := -1
+	for i, raw := range messages {
+		if isSystemMessage(raw) {
+			target = i
+			if mode == "prepend" {
+				break
+			}
+		}
+	}
+	if target < 0 {
+		return append([]interface{}{prompt}, messages...)
+	}
+
+	out := make([]interface{}, 0, len(messages)+1)
+	out = append(out, messages[:target]...)
+	message := messages[target].(map[string]interface{})
+	if text, ok := message["content"].(string); ok {
+		merged := copyMap(message)
+		if mode == "prepend" {
+			merged["content"] = content + "\n\n" + text
+		} else {
+			merged["content"] = text + "\n\n" + content
+		}
+		out = append(out, merged)
+	} else if mode == "prepend" {
+		// 内容块数组形式的系统消息不合并，作为单独的消息插入
+		out = append(out, prompt, message)
+	} else {
+		out = append(out, message, prompt)
+	}
+	return append(out, messages[target+1:]...)
+}
+
+// isSystemMessage 判断是否为system或developer消息
+func isSystemMessage(raw interface{}) bool {
+	message, ok := raw.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	role, _ := message["role"].(string)
+	return role == "system" || role == "developer"
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenizerCL100K cl100k_base编码的名称，也是用量记录中的估算方法标识
+const tokenizerCL100K = "cl100k_base"
+
+// maxBPEPieceBytes 单个预分词片段参与BPE合并的最大字节数
+// 合并的开销与片段长度的平方成正比，超长片段（如连续的字母或空白）按此长度切开后分别计数，对结果影响可以忽略
+const maxBPEPieceBytes = 256
+
+// textTokenizer 当前加载的BPE分词器，未加载时按字符数启发式估算
+var textTokenizer atomic.Pointer[bpeTokenizer]
+
+// bpeTokenizer 按tiktoken的字节级BPE计算token数，只计数不输出token ID
+type bpeTokenizer struct {
+	name  string
+	ranks map[string]int // token字节序列 -> 合并优先级（即token ID）
+}
+
+// loadBPETokenizer 读取tiktoken格式的词表文件，每行为 "base64编码的token 优先级"
+func loadBPETokenizer(path string) (*bpeTokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取BPE词表失败: %w", err)
+	}
+	ranks := make(map[string]int, 100256)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("BPE词表第%d行格式错误", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("BPE词表第%d行token解码失败: %w", line, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("BPE词表第%d行优先级无效: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取BPE词表失败: %w", err)
+	}
+	// 字节级BPE要求256个单字节都在词表中，否则任意文本无法完整切分
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("BPE词表缺少单字节token 0x%02x", b)
+		}
+	}
+	return &bpeTokenizer{name: tokenizerCL100K, ranks: ranks}, nil
+}
+
+// count 返回文本的token数：先按cl100k的规则预分词，再对每个片段做字节对合并
+func (t *bpeTokenizer) count(text string) int {
+	total := 0
+	for text != "" {
+		n := cl100kPieceLen(text)
+		piece := text[:n]
+		text = text[n:]
+		for len(piece) > maxBPEPieceBytes {
+			total += t.bytePairCount(piece[:maxBPEPieceBytes])
+			piece = piece[maxBPEPieceBytes:]
+		}
+		total += t.bytePairCount(piece)
+	}
+	return total
+}
+
+// bytePairCount 对一个片段反复合并优先级最高（rank最小）的相邻字节对，返回合并结束后的token数
+// 与tiktoken的_byte_pair_merge相同，缓存每个相邻对的rank，每次合并只重新计算两侧
+func (t *bpeTokenizer) bytePairCount(piece string) int {
+	if piece == "" {
+		return 0
+	}
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+
+	type part struct {
+		start int
+		rank  int // 从当前片段开始、由两个相邻片段组成的字节序列的rank
+	}
+	parts := make([]part, len(piece)+1)
+	// pairRank 返回parts[i]与其后一个片段合并后的rank，不在词表中时为MaxInt
+	pairRank := func(i int) int {
+		if i+2 >= len(parts) {
+			return math.MaxInt
+		}
+		if rank, ok := t.ranks[piece[parts[i].start:parts[i+2].start]]; ok {
+			return rank
+		}
+		return math.MaxInt
+	}
+	for i := range parts {
+		parts[i] = part{start: i, rank: math.MaxInt}
+	}
+	for i := 0; i+2 < len(parts); i++ {
+		parts[i].rank = pairRank(i)
+	}
+
+	for len(parts) > 2 {
+		best := -1
+		for i := 0; i+1 < len(parts); i++ {
+			if parts[i].rank != math.MaxInt && (best < 0 || parts[i].rank < parts[best].rank) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+		parts[best].rank = pairRank(best)
+		if best > 0 {
+			parts[best-1].rank = pairRank(best - 1)
+		}
+	}
+	return len(parts) - 1
+}
+
+// cl100kPieceLen 返回text开头第一个预分词片段的字节长度，等价于cl100k_base的正则：
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Go的regexp不支持(?!\S)，因此按各分支的顺序手工匹配
+func cl100kPieceLen(text string) int {
+	// 英文缩写后缀
+	if text[0] == '\'' && len(text) >= 2 {
+		if len(text) >= 3 {
+			switch lowerASCII(text[1:3]) {
+			case "re", "ve", "ll":
+				return 3
+			}
+		}
+		switch lowerASCII(text[1:2]) {
+		case "s", "t", "m", "d":
+			return 2
+		}
+	}
+
+	r, size := utf8.DecodeRuneInString(text)
+
+	// 可选的一个非字母数字前缀字符加连续的字母
+	if unicode.IsLetter(r) {
+		return size + runLen(text[size:], unicode.IsLetter)
+	}
+	if r != '\r' && r != '\n' && !unicode.IsNumber(r) {
+		if n := runLen(text[size:], unicode.IsLetter); n > 0 {
+			return size + n
+		}
+	}
+
+	// 最多3个连续数字
+	if unicode.IsNumber(r) {
+		n := size
+		for i := 1; i < 3 && n < len(text); i++ {
+			next, nextSize := utf8.DecodeRuneInString(text[n:])
+			if !unicode.IsNumber(next) {
+				break
+			}
+			n += nextSize
+		}
+		return n
+	}
+
+	// 可选的一个空格加连续的标点符号，以及其后的换行
+	start := 0
+	if r == ' ' {
+		start = 1
+	}
+	if n := runLen(text[start:], isPunct); n > 0 {
+		end := start + n
+		return end + runLen(text[end:], isNewline)
+	}
+
+	// 空白：包含换行时到最后一个换行为止；不在末尾时留下最后一个空白字符给后面的片段
+	n := runLen(text, unicode.IsSpace)
+	if n == 0 {
+		// 以上分支已覆盖所有字符，这里只是保证每次至少前进一个字符
+		return size
+	}
+	for i := n - 1; i >= 0; i-- {
+		if text[i] == '\r' || text[i] == '\n' {
+			return i + 1
+		}
+	}
+	if n == len(text) {
+		return n
+	}
+	_, lastSize := utf8.DecodeLastRuneInString(text[:n])
+	if n > lastSize {
+		return n - lastSize
+	}
+	return n
+}
+
+// runLen 返回text开头连续满足条件的字符的字节长度
+func runLen(text string, match func(rune) bool) int {
+	n := 0
+	for n < len(text) {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if !match(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cl100kPieces 按cl100k_base的预分词规则切分文本
+func cl100kPieces(text string) []string {
+	var pieces []string
+	for text != "" {
+		n := cl100kPieceLen(text)
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+func TestCL100KPieces(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"I'm here", []string{"I", "'m", " here"}},
+		{"They'LL go", []string{"They", "'LL", " go"}},
+		{"'quote", []string{"'quote"}},
+		{"12345", []string{"123", "45"}},
+		{"ab12", []string{"ab", "12"}},
+		{"a  b", []string{"a", " ", " b"}},
+		{"x \n\n y", []string{"x", " \n\n", " y"}},
+		{"end  ", []string{"end", "  "}},
+		{"foo();\n", []string{"foo", "();\n"}},
+		{"(x)", []string{"(x", ")"}},
+		{"\tif", []string{"\tif"}},
+		{"a\r\nb", []string{"a", "\r\n", "b"}},
+		{"你好，世界", []string{"你好", "，世界"}},
+		{"x　　y", []string{"x", "　", "　y"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := cl100kPieces(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cl100kPieces(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeTestVocab 写出tiktoken格式的测试词表：256个单字节加上extra中的token
+func writeTestVocab(t *testing.T, extra ...string) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, token := range extra {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	path := filepath.Join(t.TempDir(), "test.tiktoken")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBPETokenizerCount(t *testing.T) {
+	tokenizer, err := loadBPETokenizer(writeTestVocab(t, "ab", "cd", "abcd", " ab"))
+	if err != nil {
+		t.Fatalf("loadBPETokenizer: %v", err)
+	}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcdab", 2}, // ab cd ab -> abcd ab
+		{"abx", 2},
+		{"xyz", 3},
+		{"cd ab", 2},  // "cd" + " ab"
+		{"ab  ab", 3}, // "ab" + " " + " ab"
+		{"12345", 5},  // "123" "45" 各自按单字节计数
+		{"你", 3},      // 一个汉字3个字节
+		{"abab\n", 3}, // "abab" -> ab ab，"\n"
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := tokenizer.count(tt.text); got != tt.want {
+				t.Errorf("count(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBPETokenizerLongPiece(t *testing.T) {
+	tokenizer, err := loadBPETokenizer(writeTestVocab(t, "ab"))
+	if err != nil {
+		t.Fatalf("loadBPETokenizer: %v", err)
+	}
+	// 超过maxBPEPieceBytes的片段切开后计数，偶数长度的切分点不会拆开ab
+	text := strings.Repeat("ab", maxBPEPieceBytes)
+	if got, want := tokenizer.count(text), maxBPEPieceBytes; got != want {
+		t.Errorf("count = %d, want %d", got, want)
+	}
+}
+
+func TestLoadBPETokenizerErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"缺少单字节token", base64.StdEncoding.EncodeToString([]byte("a")) + " 0\n"},
+		{"格式错误", "YQ==\n"},
+		{"优先级无效", "YQ== x\n"},
+		{"base64无效", "!!! 0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadBPETokenizer(path); err == nil {
+				t.Errorf("loadBPETokenizer应返回错误")
+			}
+		})
+	}
+	if _, err := loadBPETokenizer(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("文件不存在时应返回ErrNotExist, got %v", err)
+	}
+}
+
+func TestEstimateTextTokensUsesTokenizer(t *testing.T) {
+	if got := usageEstimateMethod(); got != usageEstimateHeuristic {
+		t.Fatalf("未加载词表时estimate_method = %q", got)
+	}
+	if got := estimateTextTokens("你好abcd"); got != 3 {
+		t.Errorf("启发式估算 = %d, want 3", got)
+	}
+
+	tokenizer, err := loadBPETokenizer(writeTestVocab(t, "ab", "cd", "abcd"))
+	if err != nil {
+		t.Fatalf("loadBPETokenizer: %v", err)
+	}
+	textTokenizer.Store(tokenizer)
+	defer textTokenizer.Store(nil)
+
+	if got := usageEstimateMethod(); got != tokenizerCL100K {
+		t.Errorf("estimate_method = %q, want %q", got, tokenizerCL100K)
+	}
+	if got := estimateTextTokens("abcd"); got != 1 {
+		t.Errorf("BPE计数 = %d, want 1", got)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"unicode"
+)
+
+// usageEstimateHeuristic 未加载BPE词表时用量记录中本地估算的方法标识
+// 估算按字符数计算，不是分词器计数，与后端实际计费可能有明显偏差
+const usageEstimateHeuristic = "heuristic"
+
+// 本地token估算参数，用于后端未返回用量或路由判断等无需精确计数的场景
+const (
+	charsPerToken      = 4 // 非CJK字符约4个字符一个token
+	tokensPerMessage   = 4 // 每条消息的角色、分隔符等固定开销
+	tokensPerImagePart = 85
+)
+
+// usageEstimateMethod 返回当前本地估算使用的方法，写入用量记录的estimate_method
+func usageEstimateMethod() string {
+	if t := textTokenizer.Load(); t != nil {
+		return t.name
+	}
+	return usageEstimateHeuristic
+}
+
+// estimateTextTokens 估算文本的token数：加载了BPE词表时按cl100k_base分词计数，否则按字符数启发式估算
+func estimateTextTokens(text string) int {
+	if t := textTokenizer.Load(); t != nil {
+		return t.count(text)
+	}
+	return heuristicTextTokens(text)
+}
+
+// heuristicTextTokens 启发式估算文本的token数: CJK字符按1个token计，其余字符按4个字符1个token计
+// 英文代码通常偏差不大，中文与实际分词结果可能相差较多
+func heuristicTextTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+charsPerToken-1)/charsPerToken
+}
+
+// estimateContentTokens 估算消息content的token数，content可以是字符串或内容块数组
+func estimateContentTokens(content interface{}) int {
+	switch c := content.(type) {
+	case string:
+		return estimateTextTokens(c)
+	case []interface{}:
+		total := 0
+		for _, raw := range c {
+			part, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				total += estimateTextTokens(text)
+			} else if part["type"] == "image_url" {
+				total += tokensPerImagePart
+			}
+		}
+		return total
+	}
+	return 0
+}
+
+// estimateMessageTokens 估算单条chat消息的token数
+func estimateMessageTokens(message map[string]interface{}) int {
+	total := tokensPerMessage + estimateContentTokens(message["content"])
+	if reasoning, ok := message["reasoning_content"].(string); ok {
+		total += estimateTextTokens(reasoning)
+	}
+	if toolCalls, ok := message["tool_calls"]; ok {
+		data, _ := json.Marshal(toolCalls)
+		total += estimateTextTokens(string(data))
+	}
+	return total
+}
+
+// estimatePromptTokens 估算chat/completions请求的输入token数，包括消息和工具定义
+func estimatePromptTokens(reqJSON map[string]interface{}) int {
+	total := 0
+	messages, _ := reqJSON["messages"].([]interface{})
+	for _, raw := range messages {
+		if message, ok := raw.(map[string]interface{}); ok {
+			total += estimateMessageTokens(message)
+		}
+	}
+	if tools, ok := reqJSON["tools"]; ok {
+		data, _ := json.Marshal(tools)
+		total += estimateTextTokens(string(data))
+	}
+	return total
+}
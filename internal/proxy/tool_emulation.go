@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+// toolEmulationPrompt 工具模拟的系统提示词，%s处为工具列表
+const toolEmulationPrompt = `你可以使用以下工具。需要调用工具时，严格按如下格式输出，每个调用一个<tool_call>块，可以连续输出多个调用，输出调用后立即停止，等待工具结果：
+<tool_call>
+{"name": "工具名称", "arguments": {参数对象}}
+</tool_call>
+工具的执行结果会以<tool_result>标签返回给你。不需要调用工具时直接回答，不要输出<tool_call>。
+
+可用工具：
+%s`
+
+// emulateTools 为不支持原生工具调用的后端改写请求：
+// 工具定义写入系统提示词，历史中的tool_calls和tool消息转换为文本
+// 返回是否注入了工具，注入时需要从响应文本中解析工具调用
+func emulateTools(reqJSON map[string]interface{}) bool {
+	tools, _ := reqJSON["tools"].([]interface{})
+	toolChoice := reqJSON["tool_choice"]
+	delete(reqJSON, "tools")
+	delete(reqJSON, "tool_choice")
+	delete(reqJSON, "parallel_tool_calls")
+
+	messages, _ := reqJSON["messages"].([]interface{})
+	messages = toolMessagesToText(messages)
+
+	if len(tools) == 0 || toolChoice == "none" {
+		reqJSON["messages"] = messages
+		return false
+	}
+
+	prompt := fmt.Sprintf(toolEmulationPrompt, describeTools(tools))
+	switch choice := toolChoice.(type) {
+	case string:
+		if choice == "required" {
+			prompt += "\n本次回答必须调用至少一个工具。"
+		}
+	case map[string]interface{}:
+		function, _ := choice["function"].(map[string]interface{})
+		if name, _ := function["name"].(string); name != "" {
+			prompt += fmt.Sprintf("\n本次回答必须调用工具 %s。", name)
+		}
+	}
+
+	// 合并到已有的第一条system消息，部分后端只接受一条system消息
+	if len(messages) > 0 {
+		if first, ok := messages[0].(map[string]interface{}); ok && first["role"] == "system" {
+			if content, ok := first["content"].(string); ok {
+				merged := copyMap(first)
+				merged["content"] = content + "\n\n" + prompt
+				messages[0] = merged
+				reqJSON["messages"] = messages
+				return true
+			}
+		}
+	}
+	reqJSON["messages"] = append([]interface{}{
+		map[string]interface{}{"role": "system", "content": prompt},
+	}, messages...)
+	return true
+}
+
+// describeTools 将工具定义转换为提示词中的工具列表
+func describeTools(tools []interface{}) string {
+	var b strings.Builder
+	for _, raw := range tools {
+		tool, _ := raw.(map[string]interface{})
+		function, _ := tool["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		if name == "" {
+			continue
+		}
+		b.WriteString("- " + name)
+		if description, _ := function["description"].(string); description != "" {
+			b.WriteString(": " + description)
+		}
+		b.WriteString("\n")
+		if params, ok := function["parameters"]; ok {
+			data, _ := json.Marshal(params)
+			b.WriteString("  参数: " + string(data) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// toolMessagesToText 将assistant的tool_calls和tool消息转换为纯文本消息，
+// 连续的tool消息合并为一条user消息；消息被复制后再修改，不影响原请求
+func toolMessagesToText(messages []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(messages))
+	toolNames := map[string]string{}
+	var results []string
+
+	flushResults := func() {
+		if len(results) > 0 {
+			out = append(out, map[string]interface{}{"role": "user", "content": strings.Join(results, "\n")})
+			results = nil
+		}
+	}
+
+	for _, raw := range messages {
+		message, ok := raw.(map[string]interface{})
+		if !ok {
+			out = append(out, raw)
+			continue
+		}
+		role, _ := message["role"].(string)
+
+		if role == "tool" {
+			id, _ := message["tool_call_id"].(string)
+			name := toolNames[id]
+			if name == "" {
+				name, _ = message["name"].(string)
+			}
+			results = append(results, fmt.Sprintf("<tool_result name=%q tool_call_id=%q>\n%s\n</tool_result>", name, id, anthropicText(message["content"])))
+			continue
+		}
+		flushResults()
+
+		toolCalls, _ := message["tool_calls"].([]interface{})
+		if role != "assistant" || len(toolCalls) == 0 {
+			out = append(out, message)
+			continue
+		}
+
+		var parts []string
+		if text := anthropicText(message["content"]); text != "" {
+			parts = append(parts, text)
+		}
+		for _, rawCall := range toolCalls {
+			call, _ := rawCall.(map[string]interface{})
+			function, _ := call["function"].(map[string]interface{})
+			name, _ := function["name"].(string)
+			if id, _ := call["id"].(string); id != "" {
+				toolNames[id] = name
+			}
+			data, _ := json.Marshal(map[string]interface{}{
+				"name":      name,
+				"arguments": parseToolArguments(function["arguments"]),
+			})
+			parts = append(parts, toolCallOpenTag+"\n"+string(data)+"\n"+toolCallCloseTag)
+		}
+		converted := copyMap(message)
+		delete(converted, "tool_calls")
+		converted["content"] = strings.Join(parts, "\n")
+		out = append(out, converted)
+	}
+	flushResults()
+	return out
+}
+
+// parseEmulatedToolCall 解析<tool_call>块中的JSON，返回chat格式的tool_call
+func parseEmulatedToolCall(body string, index int) (map[string]interface{}, error) {
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	body = strings.TrimSpace(body)
+	// 兼容模型用markdown代码块包裹JSON
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.Trim(body, "`\n ")
+	if err := json.Unmarshal([]byte(body), &call); err != nil {
+		return nil, fmt.Errorf("工具调用格式无效: %w", err)
+	}
+	if call.Name == "" {
+		return nil, fmt.Errorf("工具调用缺少name")
+	}
+
+	// arguments可能是对象，也可能已经是JSON字符串
+	arguments := "{}"
+	if len(call.Arguments) > 0 && string(call.Arguments) != "null" {
+		var s string
+		if err := json.Unmarshal(call.Arguments, &s); err == nil {
+			arguments = s
+		} else {
+			arguments = string(call.Arguments)
+		}
+	}
+	return map[string]interface{}{
+		"index": index,
+		"id":    "call_" + randomHex(24),
+		"type":  "function",
+		"function": map[string]interface{}{
+			"name":      call.Name,
+			"arguments": arguments,
+		},
+	}, nil
+}
+
+// extractToolCallsResponse 从chat.completion各choice的文本中解析工具调用
+func extractToolCallsResponse(resp map[string]interface{}) {
+	choices, _ := resp["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		message, ok := choice["message"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		content, _ := message["content"].(string)
+		if !strings.Contains(content, toolCallOpenTag) {
+			continue
+		}
+
+		var text strings.Builder
+		var toolCalls []interface{}
+		rest := content
+		for {
+			start := strings.Index(rest, toolCallOpenTag)
+			if start < 0 {
+				text.WriteString(rest)
+				break
+			}
+			body := rest[start+len(toolCallOpenTag):]
+			after := ""
+			end := strings.Index(body, toolCallCloseTag)
+			if end >= 0 {
+				body, after = body[:end], body[end+len(toolCallCloseTag):]
+			}
+			call, err := parseEmulatedToolCall(body, len(toolCalls))
+			if err != nil {
+				// 无法解析的块保留为文本
+				text.WriteString(rest)
+				break
+			}
+			text.WriteString(rest[:start])
+			delete(call, "index")
+			toolCalls = append(toolCalls, call)
+			if end < 0 {
+				break
+			}
+			rest = after
+		}
+		if len(toolCalls) == 0 {
+			continue
+		}
+
+		if remaining := strings.TrimSpace(text.String()); remaining != "" {
+			message["content"] = remaining
+		} else {
+			message["content"] = nil
+		}
+		message["tool_calls"] = toolCalls
+		choice["finish_reason"] = "tool_calls"
+	}
+}
+
+// toolCallStream 从流式文本中解析工具调用，按choice保存跨chunk的状态
+type toolCallStream struct {
+	choices map[int]*toolCallChoiceState
+	model   interface{} // 上游chunk中的model，用于补全没有model的结束chunk
+}
+
+// toolCallChoiceState 单个choice的解析状态
+type toolCallChoiceState struct {
+	inCall  bool            // 正处于<tool_call>块内
+	pending string          // 可能是标签前缀、暂缓输出的文本
+	body    strings.Builder // 当前<tool_call>块的内容
+	calls   int             // 已解析出的工具调用数
+}
+
+func newToolCallStream() *toolCallStream {
+	return &toolCallStream{choices: map[int]*toolCallChoiceState{}}
+}
+
+// transform 改写一个chunk：<tool_call>块之外的文本原样输出，块结束时输出完整的tool_calls增量
+func (s *toolCallStream) transform(chunk map[string]interface{}) {
+	if model := chunk["model"]; model != nil {
+		s.model = model
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := intValue(choice["index"])
+		st, ok := s.choices[index]
+		if !ok {
+			st = &toolCallChoiceState{}
+			s.choices[index] = st
+		}
+		delta, _ := choice["delta"].(map[string]interface{})
+		if delta == nil {
+			delta = map[string]interface{}{}
+		}
+		finished := choice["finish_reason"] != nil
+		content, hadContent := delta["content"].(string)
+
+		text, toolCalls := st.consume(content, finished)
+		delete(delta, "content")
+		if text != "" || (hadContent && content == "") {
+			delta["content"] = text
+		}
+		if len(toolCalls) > 0 {
+			delta["tool_calls"] = toolCalls
+		}
+		if finished && st.calls > 0 {
+			choice["finish_reason"] = "tool_calls"
+			if chunk["model"] == nil && s.model != nil {
+				chunk["model"] = s.model
+			}
+		}
+		choice["delta"] = delta
+	}
+}
+
+// consume 处理一段文本，返回应输出的正文和解析出的工具调用
+func (st *toolCallChoiceState) consume(content string, finished bool) (string, []interface{}) {
+	text := st.pending + content
+	st.pending = ""
+	var out strings.Builder
+	var toolCalls []interface{}
+
+	for text != "" {
+		if !st.inCall {
+			if i := strings.Index(text, toolCallOpenTag); i >= 0 {
+				out.WriteString(text[:i])
+				text = text[i+len(toolCallOpenTag):]
+				st.inCall = true
+				st.body.Reset()
+				continue
+			}
+			keep := partialTagSuffix(text, toolCallOpenTag)
+			if finished {
+				keep = 0
+			}
+			out.WriteString(text[:len(text)-keep])
+			st.pending = text[len(text)-keep:]
+			break
+		}
+
+		if i := strings.Index(text, toolCallCloseTag); i >= 0 {
+			st.body.WriteString(text[:i])
+			text = text[i+len(toolCallCloseTag):]
+			st.inCall = false
+			toolCalls = st.finishCall(&out, toolCalls, true)
+			continue
+		}
+		keep := partialTagSuffix(text, toolCallCloseTag)
+		if finished {
+			keep = 0
+		}
+		st.body.WriteString(text[:len(text)-keep])
+		st.pending = text[len(text)-keep:]
+		break
+	}
+
+	// 流结束时未闭合的块也尝试解析
+	if finished && st.inCall {
+		st.inCall = false
+		toolCalls = st.finishCall(&out, toolCalls, false)
+	}
+
+	// 工具调用之间的空白不作为正文输出
+	if st.calls > 0 && strings.TrimSpace(out.String()) == "" {
+		return "", toolCalls
+	}
+	return out.String(), toolCalls
+}
+
+// finishCall 解析当前块，失败时将原文作为正文输出，closed表示块以结束标签闭合
+func (st *toolCallChoiceState) finishCall(out *strings.Builder, toolCalls []interface{}, closed bool) []interface{} {
+	call, err := parseEmulatedToolCall(st.body.String(), st.calls)
+	if err != nil {
+		out.WriteString(toolCallOpenTag + st.body.String())
+		if closed {
+			out.WriteString(toolCallCloseTag)
+		}
+		return toolCalls
+	}
+	st.calls++
+	return append(toolCalls, call)
+}
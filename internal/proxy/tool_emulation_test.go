@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"testing"
+)
+
+// toolStreamResult 工具调用流改写后客户端收到的内容
+type toolStreamResult struct {
+	content      string
+	calls        []map[string]interface{}
+	finishReason interface{}
+	finishModel  interface{}
+}
+
+// runToolCallStream 依次改写chunk，跳过改写后为空的chunk，模拟eachChunk的输出
+func runToolCallStream(t *testing.T, chunks []map[string]interface{}) toolStreamResult {
+	t.Helper()
+	s := newToolCallStream()
+	var result toolStreamResult
+	for _, chunk := range chunks {
+		s.transform(chunk)
+		if emptyChunk(chunk) {
+			continue
+		}
+		choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+		delta := choice["delta"].(map[string]interface{})
+		if len(delta) == 0 && choice["finish_reason"] == nil {
+			t.Errorf("输出了空delta的chunk: %v", chunk)
+		}
+		if text, ok := delta["content"].(string); ok {
+			result.content += text
+		}
+		if calls, ok := delta["tool_calls"].([]interface{}); ok {
+			for _, call := range calls {
+				result.calls = append(result.calls, call.(map[string]interface{}))
+			}
+		}
+		if choice["finish_reason"] != nil {
+			result.finishReason = choice["finish_reason"]
+			result.finishModel = chunk["model"]
+		}
+	}
+	return result
+}
+
+func TestToolCallStream(t *testing.T) {
+	type wantCall struct {
+		name      string
+		arguments string
+	}
+	tests := []struct {
+		name       string
+		chunks     []string
+		wantText   string
+		wantCalls  []wantCall
+		wantFinish string
+	}{
+		{
+			name:       "标签拆分在多个chunk中",
+			chunks:     []string{"好的<tool", "_call>\n{\"name\": \"read\", ", "\"arguments\": {\"path\": \"a.go\"}}\n</tool_", "call>"},
+			wantText:   "好的",
+			wantCalls:  []wantCall{{"read", `{"path": "a.go"}`}},
+			wantFinish: "tool_calls",
+		},
+		{
+			name:       "块内JSON无效时按正文输出",
+			chunks:     []string{"<tool_call>{\"name\": read}", "</tool_call>"},
+			wantText:   "<tool_call>{\"name\": read}</tool_call>",
+			wantFinish: "stop",
+		},
+		{
+			name: "多个工具调用",
+			chunks: []string{
+				"<tool_call>{\"name\": \"a\", \"arguments\": {}}</tool_call>\n",
+				"<tool_call>{\"name\": \"b\", \"arguments\": \"{\\\"x\\\":1}\"}</tool_call>",
+			},
+			wantCalls:  []wantCall{{"a", "{}"}, {"b", `{"x":1}`}},
+			wantFinish: "tool_calls",
+		},
+		{
+			name:       "未闭合的块在结束时解析",
+			chunks:     []string{"<tool_call>{\"name\": \"a\"}"},
+			wantCalls:  []wantCall{{"a", "{}"}},
+			wantFinish: "tool_calls",
+		},
+		{
+			name:       "没有工具调用",
+			chunks:     []string{"a <b> c", " <tool"},
+			wantText:   "a <b> c <tool",
+			wantFinish: "stop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks []map[string]interface{}
+			for _, text := range tt.chunks {
+				chunks = append(chunks, contentChunk(text, nil))
+			}
+			// 部分上游的结束chunk没有model
+			chunks = append(chunks, map[string]interface{}{
+				"choices": []interface{}{
+					map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": "stop"},
+				},
+			})
+
+			result := runToolCallStream(t, chunks)
+			if result.content != tt.wantText {
+				t.Errorf("content = %q, want %q", result.content, tt.wantText)
+			}
+			if len(result.calls) != len(tt.wantCalls) {
+				t.Fatalf("tool_calls = %v, want %d个", result.calls, len(tt.wantCalls))
+			}
+			for i, call := range result.calls {
+				function := call["function"].(map[string]interface{})
+				if function["name"] != tt.wantCalls[i].name || function["arguments"] != tt.wantCalls[i].arguments {
+					t.Errorf("tool_calls[%d] = %v, want %v", i, function, tt.wantCalls[i])
+				}
+				if call["index"] != i {
+					t.Errorf("tool_calls[%d].index = %v", i, call["index"])
+				}
+			}
+			if result.finishReason != tt.wantFinish {
+				t.Errorf("finish_reason = %v, want %s", result.finishReason, tt.wantFinish)
+			}
+			if tt.wantFinish == "tool_calls" && result.finishModel != "test-model" {
+				t.Errorf("结束chunk的model = %v, want test-model", result.finishModel)
+			}
+		})
+	}
+}
+
+func TestExtractToolCallsResponse(t *testing.T) {
+	resp := map[string]interface{}{
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"finish_reason": "stop",
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": "先读文件\n<tool_call>\n{\"name\": \"read\", \"arguments\": {\"path\": \"a\"}}\n</tool_call>",
+				},
+			},
+		},
+	}
+	extractToolCallsResponse(resp)
+	choice := resp["choices"].([]interface{})[0].(map[string]interface{})
+	message := choice["message"].(map[string]interface{})
+	if choice["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v", choice["finish_reason"])
+	}
+	calls, _ := message["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("tool_calls = %v", message["tool_calls"])
+	}
+	if content, _ := message["content"].(string); content != "先读文件" {
+		t.Errorf("content = %q", content)
+	}
+}
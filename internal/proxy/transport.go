@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+// 后端连接默认参数，与http.DefaultTransport保持一致
+const (
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+)
+
+// backendClients 为每个后端维护一个共享的http.Client，复用连接池
+type backendClients struct {
+	mu      sync.Mutex
+	clients map[*models.API]*http.Client
+}
+
+func newBackendClients() *backendClients {
+	return &backendClients{clients: map[*models.API]*http.Client{}}
+}
+
+// get 返回后端的http.Client，首次使用时按transport和upstream_proxy配置创建
+func (c *backendClients) get(backend *models.API) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, ok := c.clients[backend]
+	if !ok {
+		var err error
+		client, err = newBackendClient(backend)
+		if err != nil {
+			return nil, err
+		}
+		c.clients[backend] = client
+	}
+	return client, nil
+}
+
+// newBackendClient 按后端配置创建http.Client
+func newBackendClient(backend *models.API) (*http.Client, error) {
+	proxyFunc, err := config.UpstreamProxyFunc(backend.UpstreamProxy)
+	if err != nil {
+		return nil, fmt.Errorf("出站代理配置无效: %w", err)
+	}
+
+	cfg := backend.Transport
+	if cfg == nil {
+		cfg = &models.TransportConfig{}
+	}
+
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	tlsHandshakeTimeout := cfg.TLSHandshakeTimeout
+	if tlsHandshakeTimeout <= 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	idleConnTimeout := cfg.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+
+	transport := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.FirstByteTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		ForceAttemptHTTP2:     cfg.HTTP2 == nil || *cfg.HTTP2,
+	}
+	if cfg.HTTP2 != nil && !*cfg.HTTP2 {
+		// 非nil的空TLSNextProto会禁用HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.TotalTimeout,
+	}, nil
+}
+
+// errIdleTimeout 后端流式响应在idle_stream_timeout内没有新数据
+var errIdleTimeout = errors.New("后端响应空闲超时")
+
+// idleTimeoutBody 读取响应体时等待数据超过指定时间则关闭连接
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+// withIdleTimeout 为响应体加上空闲超时，timeout<=0时原样返回
+func withIdleTimeout(body io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.timedOut.Store(true)
+		body.Close()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	// 只统计等待后端数据的时间，向客户端写出的耗时不计入
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.timedOut.Load() {
+		return n, fmt.Errorf("%w（%v）", errIdleTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
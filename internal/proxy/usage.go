@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"trae-proxy-go/internal/config"
+	"trae-proxy-go/pkg/models"
+)
+
+// usageTracker 统计单个请求的token用量：优先使用后端返回的usage，没有时按本地估算
+type usageTracker struct {
+	promptEstimate  int  // 转发给后端的请求的输入token估算值
+	injected        bool // 代理注入了stream_options.include_usage
+	clientRequested bool // 客户端自己请求了include_usage
+	usage           map[string]interface{}
+	content         strings.Builder // 后端输出的正文和工具调用，用于估算输出token
+	reasoning       strings.Builder // 后端输出的推理内容
+}
+
+// injectStreamUsage 流式请求注入stream_options.include_usage，返回用量统计器
+// 非流式请求的响应本身带有usage，不需要注入
+func injectStreamUsage(reqJSON map[string]interface{}, backend *models.API) *usageTracker {
+	tracker := &usageTracker{}
+	options, _ := reqJSON["stream_options"].(map[string]interface{})
+	tracker.clientRequested, _ = options["include_usage"].(bool)
+
+	stream, _ := reqJSON["stream"].(bool)
+	if !stream || tracker.clientRequested || (backend.StreamUsage != nil && !*backend.StreamUsage) {
+		return tracker
+	}
+	// stream_options可能与其他候选后端的请求共用，复制后再修改
+	injected := map[string]interface{}{}
+	for k, v := range options {
+		injected[k] = v
+	}
+	injected["include_usage"] = true
+	reqJSON["stream_options"] = injected
+	tracker.injected = true
+	return tracker
+}
+
+// stripUsage 是否需要从返回给客户端的流中删除usage（usage由代理注入，客户端没有请求）
+func (t *usageTracker) stripUsage() bool {
+	return t.injected && !t.clientRequested
+}
+
+// observeChunk 记录一个chunk中的usage和输出内容
+func (t *usageTracker) observeChunk(chunk map[string]interface{}) {
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		t.usage = usage
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if delta, ok := choice["delta"].(map[string]interface{}); ok {
+			t.observeMessage(delta)
+		}
+	}
+}
+
+// observeResponse 记录chat.completion中的usage和输出内容
+func (t *usageTracker) observeResponse(resp map[string]interface{}) {
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		t.usage = usage
+	}
+	choices, _ := resp["choices"].([]interface{})
+	for _, raw := range choices {
+		choice, _ := raw.(map[string]interface{})
+		if message, ok := choice["message"].(map[string]interface{}); ok {
+			t.observeMessage(message)
+		}
+	}
+}
+
+// observeMessage 累计消息或delta中的输出文本
+func (t *usageTracker) observeMessage(message map[string]interface{}) {
+	if content, ok := message["content"].(string); ok {
+		t.content.WriteString(content)
+	}
+	if reasoning, ok := message["reasoning_content"].(string); ok {
+		t.reasoning.WriteString(reasoning)
+	}
+	toolCalls, _ := message["tool_calls"].([]interface{})
+	for _, rawCall := range toolCalls {
+		call, _ := rawCall.(map[string]interface{})
+		function, _ := call["function"].(map[string]interface{})
+		if name, ok := function["name"].(string); ok {
+			t.content.WriteString(name)
+		}
+		if args, ok := function["arguments"].(string); ok {
+			t.content.WriteString(args)
+		}
+	}
+}
+
+// observeLine 解析透传的SSE行
+func (t *usageTracker) observeLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	payload := bytes.TrimSpace(line[len("data:"):])
+	if len(payload) == 0 || payload[0] != '{' {
+		return
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(payload, &chunk); err == nil {
+		t.observeChunk(chunk)
+	}
+}
+
+// fill 将用量写入记录，后端未返回usage时按本地估算
+func (t *usageTracker) fill(rec *models.UsageRecord) {
+	if t.usage != nil {
+		rec.PromptTokens = intValue(t.usage["prompt_tokens"])
+		rec.CompletionTokens = intValue(t.usage["completion_tokens"])
+		rec.TotalTokens = intValue(t.usage["total_tokens"])
+		if details, ok := t.usage["completion_tokens_details"].(map[string]interface{}); ok {
+			rec.ReasoningTokens = intValue(details["reasoning_tokens"])
+		}
+		if details, ok := t.usage["prompt_tokens_details"].(map[string]interface{}); ok {
+			rec.CachedTokens = intValue(details["cached_tokens"])
+		}
+		if rec.CachedTokens == 0 {
+			// DeepSeek的缓存命中字段
+			rec.CachedTokens = intValue(t.usage["prompt_cache_hit_tokens"])
+		}
+		// 后端没有单独返回推理token时按推理内容估算，推理token包含在输出token中
+		if rec.ReasoningTokens == 0 && t.reasoning.Len() > 0 {
+			rec.ReasoningTokens = min(estimateTextTokens(t.reasoning.String()), rec.CompletionTokens)
+		}
+	} else {
+		rec.Estimated = true
+		rec.EstimateMethod = usageEstimateMethod()
+		rec.PromptTokens = t.promptEstimate
+		rec.ReasoningTokens = estimateTextTokens(t.reasoning.String())
+		rec.CompletionTokens = estimateTextTokens(t.content.String()) + rec.ReasoningTokens
+	}
+	if rec.TotalTokens == 0 {
+		rec.TotalTokens = rec.PromptTokens + rec.CompletionTokens
+	}
+}
+
+// usageTapReader 透传流式响应体，同时按行解析其中的chunk统计用量
+type usageTapReader struct {
+	r       io.Reader
+	tracker *usageTracker
+	line    []byte
+}
+
+func (t *usageTapReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.line = append(t.line, data...)
+			break
+		}
+		t.line = append(t.line, data[:i]...)
+		t.tracker.observeLine(t.line)
+		t.line = t.line[:0]
+		data = data[i+1:]
+	}
+	return n, err
+}
+
+// newUsageRecord 创建请求的用量记录，token数由调用方填写
+func newUsageRecord(r *http.Request, backend *models.API, model string, stream bool) models.UsageRecord {
+	return models.UsageRecord{
+		Time:        time.Now(),
+		Client:      clientName(r),
+		Backend:     backend.Name,
+		Model:       model,
+		TargetModel: backend.TargetModelID,
+		Path:        r.URL.Path,
+		Stream:      stream,
+	}
+}
+
+// recordUsage 生成请求的用量记录，后端返回错误状态码时记为失败，不计费用
+func (h *Handler) recordUsage(r *http.Request, upstream *upstreamResponse, stream bool) {
+	rec := newUsageRecord(r, upstream.backend, upstream.model, stream)
+	upstream.usage.fill(&rec)
+	rec.Cost, rec.Currency = config.UsageCost(upstream.backend.Pricing, &rec)
+	if upstream.resp.StatusCode >= 400 {
+		rec.Status = upstream.resp.StatusCode
+		rec.Cost = 0
+	}
+	h.writeUsage(&rec)
+}
+
+// recordFailedUsage 后端没有返回响应时（包括所有候选后端均失败和摘要调用失败），按最后尝试的后端记录估算的输入token
+func (h *Handler) recordFailedUsage(r *http.Request, backend *models.API, model string, reqJSON map[string]interface{}, err error) {
+	stream, _ := reqJSON["stream"].(bool)
+	rec := newUsageRecord(r, backend, model, stream)
+	rec.Estimated = true
+	rec.EstimateMethod = usageEstimateMethod()
+	rec.PromptTokens = estimatePromptTokens(reqJSON)
+	rec.TotalTokens = rec.PromptTokens
+	rec.Status = http.StatusServiceUnavailable
+	if pe, ok := err.(*proxyError); ok {
+		rec.Status = pe.status
+	}
+	_, rec.Currency = config.UsageCost(backend.Pricing, &rec)
+	h.writeUsage(&rec)
+}
+
+// writeUsage 记录日志并写入用量账本
+func (h *Handler) writeUsage(rec *models.UsageRecord) {
+	if h.logger != nil {
+		estimated := ""
+		if rec.Estimated {
+			estimated = "（估算）"
+		}
+		if rec.Status != 0 {
+			estimated += fmt.Sprintf("（失败 %d）", rec.Status)
+		}
+		h.logger.Info("用量%s: 客户端 %s 后端 %s 模型 %s 输入 %d（缓存 %d）输出 %d（推理 %d）", estimated, rec.Client, rec.Backend, rec.Model, rec.PromptTokens, rec.CachedTokens, rec.CompletionTokens, rec.ReasoningTokens)
+	}
+	if h.ledger == nil {
+		return
+	}
+	if err := h.ledger.Append(rec); err != nil && h.logger != nil {
+		h.logger.Error("写入用量账本失败: %v", err)
+	}
+}
+
+// clientName 返回用量记录中的客户端标识
+func clientName(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get("X-Client-Name")); name != "" {
+		return name
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -178,7 +178,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case viewAdd:
 		m.addView, cmd = m.addView.update(msg)
-		if m.addView.done {
+		if m.addView.done && m.addView.canceled {
+			m.view = viewList
+		} else if m.addView.done {
 			if m.addView.err == nil {
 				// 保存新API
 				newAPI := models.API{
@@ -188,6 +190,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					TargetModelID: m.addView.targetModel.Value(),
 					StreamMode:    m.addView.getStreamMode(),
 					Active:        m.addView.active,
+					APIKey:        m.addView.apiKey.Value(),
 				}
 				if newAPI.Active {
 					for i := range m.config.APIs {
@@ -207,7 +210,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case viewEdit:
 		m.editView, cmd = m.editView.update(msg)
-		if m.editView.done {
+		if m.editView.done && m.editView.canceled {
+			m.view = viewList
+		} else if m.editView.done {
 			if m.editView.err == nil && m.editView.index >= 0 && m.editView.index < len(m.config.APIs) {
 				// 更新API
 				api := &m.config.APIs[m.editView.index]
@@ -224,6 +229,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					api.TargetModelID = m.editView.targetModel.Value()
 				}
 				api.StreamMode = m.editView.getStreamMode()
+				switch m.editView.apiKey.Value() {
+				case "":
+				case "none":
+					api.APIKey = ""
+				default:
+					api.APIKey = m.editView.apiKey.Value()
+				}
 				if m.editView.setActive {
 					api.Active = m.editView.active
 					if api.Active {
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"trae-proxy-go/internal/autoconfig"
 	"trae-proxy-go/internal/cert"
+	"trae-proxy-go/internal/config"
 	"trae-proxy-go/pkg/models"
 
 	"github.com/charmbracelet/bubbles/textinput"
@@ -130,6 +131,8 @@ func (m listViewModel) view(mode vimMode) string {
 			s.WriteString(style.Render(fmt.Sprintf("   目标模型ID: %s", api.TargetModelID)))
 			s.WriteString("\n")
 			s.WriteString(style.Render(fmt.Sprintf("   流模式: %s", streamMode)))
+			s.WriteString("\n")
+			s.WriteString(style.Render(fmt.Sprintf("   API密钥: %s", config.MaskAPIKey(api.APIKey))))
 			s.WriteString("\n\n")
 		}
 	}
@@ -139,6 +142,14 @@ func (m listViewModel) view(mode vimMode) string {
 	return s.String()
 }
 
+// 添加和编辑表单中各项的焦点下标，0-4为名称、URL、模型ID和流模式输入框
+const (
+	formFocusAPIKey = 5
+	formFocusActive = 6
+	formFocusSave   = 7
+	formFocusCancel = 8
+)
+
 // 添加视图
 type addViewModel struct {
 	name        textinput.Model
@@ -146,9 +157,11 @@ type addViewModel struct {
 	customModel textinput.Model
 	targetModel textinput.Model
 	streamMode  textinput.Model
+	apiKey      textinput.Model
 	active      bool
 	focused     int
 	done        bool
+	canceled    bool
 	err         error
 }
 
@@ -167,7 +180,11 @@ func newAddView() addViewModel {
 	targetModel.Placeholder = "target-model-id"
 
 	streamMode := textinput.New()
-	streamMode.Placeholder = "none/true/false"
+	streamMode.Placeholder = "none/true/false/simulate"
+
+	apiKey := textinput.New()
+	apiKey.Placeholder = "sk-xxx / ${ENV} / file:路径"
+	apiKey.EchoMode = textinput.EchoPassword
 
 	return addViewModel{
 		name:        name,
@@ -175,6 +192,7 @@ func newAddView() addViewModel {
 		customModel: customModel,
 		targetModel: targetModel,
 		streamMode:  streamMode,
+		apiKey:      apiKey,
 		active:      false,
 		focused:     0,
 	}
@@ -186,22 +204,25 @@ func (m addViewModel) update(msg tea.Msg) (addViewModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
+		case " ":
+			if m.focused == formFocusActive {
+				m.active = !m.active
+				return m, nil
+			}
 		case "tab", "shift+tab", "enter", "up", "down":
 			s := msg.String()
 			if s == "enter" {
-				if m.focused == 5 {
-					// 保存
+				switch m.focused {
+				case formFocusActive:
+					m.active = !m.active
+					return m, nil
+				case formFocusSave:
 					m.done = true
 					m.err = m.validate()
 					return m, nil
-				}
-				if m.focused == 6 {
-					// 取消
+				case formFocusCancel:
 					m.done = true
-					return m, nil
-				}
-				if m.focused == 4 {
-					m.active = !m.active
+					m.canceled = true
 					return m, nil
 				}
 			}
@@ -212,10 +233,10 @@ func (m addViewModel) update(msg tea.Msg) (addViewModel, tea.Cmd) {
 				m.focused++
 			}
 
-			if m.focused > 6 {
+			if m.focused > formFocusCancel {
 				m.focused = 0
 			} else if m.focused < 0 {
-				m.focused = 6
+				m.focused = formFocusCancel
 			}
 
 			m.name.Blur()
@@ -223,6 +244,7 @@ func (m addViewModel) update(msg tea.Msg) (addViewModel, tea.Cmd) {
 			m.customModel.Blur()
 			m.targetModel.Blur()
 			m.streamMode.Blur()
+			m.apiKey.Blur()
 
 			switch m.focused {
 			case 0:
@@ -235,6 +257,8 @@ func (m addViewModel) update(msg tea.Msg) (addViewModel, tea.Cmd) {
 				m.targetModel.Focus()
 			case 4:
 				m.streamMode.Focus()
+			case formFocusAPIKey:
+				m.apiKey.Focus()
 			}
 			return m, nil
 		}
@@ -251,6 +275,8 @@ func (m addViewModel) update(msg tea.Msg) (addViewModel, tea.Cmd) {
 		m.targetModel, cmd = m.targetModel.Update(msg)
 	case 4:
 		m.streamMode, cmd = m.streamMode.Update(msg)
+	case formFocusAPIKey:
+		m.apiKey, cmd = m.apiKey.Update(msg)
 	}
 
 	return m, cmd
@@ -272,6 +298,9 @@ func (m addViewModel) validate() error {
 	if m.targetModel.Value() == "" {
 		return fmt.Errorf("目标模型ID不能为空")
 	}
+	if err := config.ValidateAPIKey(m.apiKey.Value()); err != nil {
+		return fmt.Errorf("无效的API密钥: %v", err)
+	}
 	return nil
 }
 
@@ -293,16 +322,17 @@ func (m addViewModel) view() string {
 	}
 
 	s.WriteString(borderStyle.Render(fmt.Sprintf(
-		"%s\n%s\n%s\n%s\n%s\n\n%s激活: %s\n\n%s保存 [回车]%s取消 [q]",
+		"%s\n%s\n%s\n%s\n%s\n%s\n\n%s 激活 %s\n\n%s  %s",
 		makeInputField("名称", m.name, m.focused == 0),
 		makeInputField("后端API URL", m.endpoint, m.focused == 1),
 		makeInputField("自定义模型ID", m.customModel, m.focused == 2),
 		makeInputField("目标模型ID", m.targetModel, m.focused == 3),
-		makeInputField("流模式 (none/true/false)", m.streamMode, m.focused == 4),
-		getCheckbox("", m.active, m.focused == 5),
+		makeInputField("流模式 (none/true/false/simulate)", m.streamMode, m.focused == 4),
+		makeInputField("API密钥 (可选)", m.apiKey, m.focused == 5),
+		getCheckbox("", m.active, m.focused == formFocusActive),
 		helpStyle.Render("[空格]切换"),
-		helpStyle.Render(""),
-		helpStyle.Render(""),
+		makeButton("保存", m.focused == formFocusSave),
+		makeButton("取消", m.focused == formFocusCancel),
 	)))
 	return s.String()
 }
@@ -315,10 +345,12 @@ type editViewModel struct {
 	customModel textinput.Model
 	targetModel textinput.Model
 	streamMode  textinput.Model
+	apiKey      textinput.Model
 	active      bool
 	setActive   bool
 	focused     int
 	done        bool
+	canceled    bool
 	err         error
 }
 
@@ -349,6 +381,11 @@ func newEditViewFromAPI(api models.API) editViewModel {
 		streamMode.SetValue("none")
 	}
 
+	// 密钥不回显原值，留空表示保持不变
+	apiKey := textinput.New()
+	apiKey.Placeholder = config.MaskAPIKey(api.APIKey)
+	apiKey.EchoMode = textinput.EchoPassword
+
 	return editViewModel{
 		index:       -1, // 需要在外部设置
 		name:        name,
@@ -356,6 +393,7 @@ func newEditViewFromAPI(api models.API) editViewModel {
 		customModel: customModel,
 		targetModel: targetModel,
 		streamMode:  streamMode,
+		apiKey:      apiKey,
 		active:      api.Active,
 		setActive:   false,
 		focused:     0,
@@ -368,21 +406,27 @@ func (m editViewModel) update(msg tea.Msg) (editViewModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
+		case " ":
+			if m.focused == formFocusActive {
+				m.active = !m.active
+				m.setActive = true
+				return m, nil
+			}
 		case "tab", "shift+tab", "enter", "up", "down":
 			s := msg.String()
 			if s == "enter" {
-				if m.focused == 5 {
+				switch m.focused {
+				case formFocusActive:
+					m.active = !m.active
+					m.setActive = true
+					return m, nil
+				case formFocusSave:
 					m.done = true
 					m.err = m.validate()
 					return m, nil
-				}
-				if m.focused == 6 {
+				case formFocusCancel:
 					m.done = true
-					return m, nil
-				}
-				if m.focused == 4 {
-					m.active = !m.active
-					m.setActive = true
+					m.canceled = true
 					return m, nil
 				}
 			}
@@ -393,10 +437,10 @@ func (m editViewModel) update(msg tea.Msg) (editViewModel, tea.Cmd) {
 				m.focused++
 			}
 
-			if m.focused > 6 {
+			if m.focused > formFocusCancel {
 				m.focused = 0
 			} else if m.focused < 0 {
-				m.focused = 6
+				m.focused = formFocusCancel
 			}
 
 			m.name.Blur()
@@ -404,6 +448,7 @@ func (m editViewModel) update(msg tea.Msg) (editViewModel, tea.Cmd) {
 			m.customModel.Blur()
 			m.targetModel.Blur()
 			m.streamMode.Blur()
+			m.apiKey.Blur()
 
 			switch m.focused {
 			case 0:
@@ -416,6 +461,8 @@ func (m editViewModel) update(msg tea.Msg) (editViewModel, tea.Cmd) {
 				m.targetModel.Focus()
 			case 4:
 				m.streamMode.Focus()
+			case formFocusAPIKey:
+				m.apiKey.Focus()
 			}
 			return m, nil
 		}
@@ -432,6 +479,8 @@ func (m editViewModel) update(msg tea.Msg) (editViewModel, tea.Cmd) {
 		m.targetModel, cmd = m.targetModel.Update(msg)
 	case 4:
 		m.streamMode, cmd = m.streamMode.Update(msg)
+	case formFocusAPIKey:
+		m.apiKey, cmd = m.apiKey.Update(msg)
 	}
 
 	return m, cmd
@@ -443,6 +492,11 @@ func (m editViewModel) validate() error {
 			return fmt.Errorf("无效的API URL格式: %v", err)
 		}
 	}
+	if m.apiKey.Value() != "none" {
+		if err := config.ValidateAPIKey(m.apiKey.Value()); err != nil {
+			return fmt.Errorf("无效的API密钥: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -464,16 +518,17 @@ func (m editViewModel) view() string {
 	}
 
 	s.WriteString(borderStyle.Render(fmt.Sprintf(
-		"%s\n%s\n%s\n%s\n%s\n\n%s激活: %s\n\n%s保存 [回车]%s取消 [q]",
+		"%s\n%s\n%s\n%s\n%s\n%s\n\n%s 激活 %s\n\n%s  %s",
 		makeInputField("名称 (留空保持原值)", m.name, m.focused == 0),
 		makeInputField("后端API URL (留空保持原值)", m.endpoint, m.focused == 1),
 		makeInputField("自定义模型ID (留空保持原值)", m.customModel, m.focused == 2),
 		makeInputField("目标模型ID (留空保持原值)", m.targetModel, m.focused == 3),
-		makeInputField("流模式 (none/true/false)", m.streamMode, m.focused == 4),
-		getCheckbox("", m.active, m.focused == 5),
+		makeInputField("流模式 (none/true/false/simulate)", m.streamMode, m.focused == 4),
+		makeInputField("API密钥 (留空保持原值, none清除)", m.apiKey, m.focused == 5),
+		getCheckbox("", m.active, m.focused == formFocusActive),
 		helpStyle.Render("[空格]切换"),
-		helpStyle.Render(""),
-		helpStyle.Render(""),
+		makeButton("保存", m.focused == formFocusSave),
+		makeButton("取消", m.focused == formFocusCancel),
 	)))
 	return s.String()
 }
@@ -692,6 +747,15 @@ func makeInputField(label string, input textinput.Model, focused bool) string {
 	return fmt.Sprintf("%s:\n%s", label, style.Render(input.View()))
 }
 
+func makeButton(label string, focused bool) string {
+	style := lipgloss.NewStyle()
+	if focused {
+		style = style.Foreground(lipgloss.Color("170"))
+		return style.Render(fmt.Sprintf("> %s [回车]", label))
+	}
+	return style.Render(fmt.Sprintf("  %s", label))
+}
+
 func getCheckbox(label string, checked bool, focused bool) string {
 	checkbox := "[ ]"
 	if checked {
@@ -1,13 +1,114 @@
 package models
 
+import "time"
+
 // API 配置结构
 type API struct {
 	Name          string `yaml:"name" json:"name"`
 	Endpoint      string `yaml:"endpoint" json:"endpoint"`
 	CustomModelID string `yaml:"custom_model_id" json:"custom_model_id"`
 	TargetModelID string `yaml:"target_model_id" json:"target_model_id"`
-	StreamMode    string `yaml:"stream_mode" json:"stream_mode"` // "true", "false", or null
+	StreamMode    string `yaml:"stream_mode" json:"stream_mode"` // 请求后端的流模式: "true", "false", "simulate", or null（跟随客户端）
 	Active        bool   `yaml:"active" json:"active"`
+	// ClientStreamMode 返回给客户端的流模式: "true", "false" 或空（跟随客户端请求）
+	ClientStreamMode string `yaml:"client_stream_mode,omitempty" json:"client_stream_mode,omitempty"`
+	// Priority 共用同一个自定义模型ID的后端按priority从小到大依次尝试
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+	// Weight 加权随机负载均衡时的权重，默认1
+	Weight int `yaml:"weight,omitempty" json:"weight,omitempty"`
+	// Simulate 后端非流式而客户端要求流式时的模拟输出配置
+	Simulate *SimulateConfig `yaml:"simulate,omitempty" json:"simulate,omitempty"`
+	// APIKey 后端密钥: 明文、${ENV} 环境变量引用或 file:路径 密钥文件
+	APIKey string `yaml:"api_key,omitempty" json:"api_key,omitempty"`
+	// CircuitBreaker 该后端的熔断器配置，未设置时使用全局circuit_breaker
+	CircuitBreaker *CircuitBreaker `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// Retry 该后端的重试策略，未设置时使用全局retry
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// UpstreamProxy 访问该后端使用的出站代理: http://、https://、socks5:// 地址，direct 直连，env 或空则使用环境变量
+	UpstreamProxy string `yaml:"upstream_proxy,omitempty" json:"upstream_proxy,omitempty"`
+	// Params 转发前对请求参数的调整，可用于把同一后端暴露为多个不同参数的模型
+	Params *ParamPreset `yaml:"params,omitempty" json:"params,omitempty"`
+	// Compat 兼容性配置名称，内置 deepseek、moonshot、dashscope，也可以是compat_profiles中的自定义配置
+	Compat string `yaml:"compat,omitempty" json:"compat,omitempty"`
+	// Transport 连接该后端的超时与连接池配置
+	Transport *TransportConfig `yaml:"transport,omitempty" json:"transport,omitempty"`
+	// ReasoningMode 推理内容处理方式: passthrough（默认）、drop、fold（并入content的<think>标签）、extract（从content的<think>标签提取）
+	ReasoningMode string `yaml:"reasoning_mode,omitempty" json:"reasoning_mode,omitempty"`
+	// ToolEmulation 后端不支持原生工具调用时，将工具定义写入系统提示词并从回复文本中解析工具调用
+	ToolEmulation bool `yaml:"tool_emulation,omitempty" json:"tool_emulation,omitempty"`
+	// SystemPrompts 转发前按顺序应用的系统提示词规则
+	SystemPrompts []SystemPromptRule `yaml:"system_prompts,omitempty" json:"system_prompts,omitempty"`
+	// Context 上下文窗口配置，估算的输入超出窗口时裁剪或摘要较早的对话
+	Context *ContextConfig `yaml:"context,omitempty" json:"context,omitempty"`
+	// StreamUsage 流式请求是否注入stream_options.include_usage，默认true；后端不支持时设为false
+	StreamUsage *bool `yaml:"stream_usage,omitempty" json:"stream_usage,omitempty"`
+	// Pricing 该后端的价格，用于计算用量记录的费用
+	Pricing *Pricing `yaml:"pricing,omitempty" json:"pricing,omitempty"`
+}
+
+// Pricing 后端价格，单位为每百万token
+type Pricing struct {
+	Currency    string  `yaml:"currency,omitempty" json:"currency,omitempty"`         // CNY（默认）或 USD
+	Input       float64 `yaml:"input" json:"input"`                                   // 输入价格
+	Output      float64 `yaml:"output" json:"output"`                                 // 输出价格（包括推理token）
+	CachedInput float64 `yaml:"cached_input,omitempty" json:"cached_input,omitempty"` // 缓存命中的输入价格，未设置时按输入价格计
+}
+
+// ContextConfig 上下文窗口管理配置
+type ContextConfig struct {
+	Window         int    `yaml:"window" json:"window"`                                       // 上下文窗口token数
+	ReserveOutput  int    `yaml:"reserve_output,omitempty" json:"reserve_output,omitempty"`   // 为输出预留的token数，请求的max_tokens更大时以请求为准
+	Strategy       string `yaml:"strategy,omitempty" json:"strategy,omitempty"`               // truncate（默认）: 删除最早的对话；summarize: 用summary_backend摘要被删除的对话
+	KeepTurns      int    `yaml:"keep_turns,omitempty" json:"keep_turns,omitempty"`           // 始终保留的最近对话轮数，默认1
+	SummaryBackend string `yaml:"summary_backend,omitempty" json:"summary_backend,omitempty"` // 生成摘要使用的后端名称
+}
+
+// SystemPromptRule 系统提示词规则
+// content 支持模板变量 {{backend}}、{{model}}（客户端请求的模型）、{{target_model}} 和 {{date}}
+type SystemPromptRule struct {
+	Mode    string `yaml:"mode" json:"mode"`       // prepend: 加在系统消息开头；append: 加在系统消息末尾；replace: 替换所有系统消息
+	Content string `yaml:"content" json:"content"` // 提示词内容
+}
+
+// TransportConfig 后端HTTP连接配置，超时为0表示使用默认值或不限制
+type TransportConfig struct {
+	ConnectTimeout      time.Duration `yaml:"connect_timeout,omitempty" json:"connect_timeout,omitempty"`                 // 建立TCP连接超时，默认30s
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout,omitempty" json:"tls_handshake_timeout,omitempty"`     // TLS握手超时，默认10s
+	FirstByteTimeout    time.Duration `yaml:"first_byte_timeout,omitempty" json:"first_byte_timeout,omitempty"`           // 发出请求到收到响应头的超时，默认不限制
+	IdleStreamTimeout   time.Duration `yaml:"idle_stream_timeout,omitempty" json:"idle_stream_timeout,omitempty"`         // 读取响应体时两次收到数据的最大间隔，默认不限制
+	TotalTimeout        time.Duration `yaml:"total_timeout,omitempty" json:"total_timeout,omitempty"`                     // 整个请求（含读取完响应体）的超时，默认不限制
+	MaxIdleConns        int           `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty"`                   // 连接池最大空闲连接数，默认100
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host,omitempty" json:"max_idle_conns_per_host,omitempty"` // 每个主机的最大空闲连接数，默认2
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host,omitempty" json:"max_conns_per_host,omitempty"`           // 每个主机的最大连接数，默认不限制
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout,omitempty" json:"idle_conn_timeout,omitempty"`             // 空闲连接保留时间，默认90s
+	HTTP2               *bool         `yaml:"http2,omitempty" json:"http2,omitempty"`                                     // 是否尝试HTTP/2，默认true
+}
+
+// ParamPreset 请求参数预设
+type ParamPreset struct {
+	Defaults  map[string]interface{} `yaml:"defaults,omitempty" json:"defaults,omitempty"`   // 客户端未设置时使用的参数
+	Overrides map[string]interface{} `yaml:"overrides,omitempty" json:"overrides,omitempty"` // 强制覆盖的参数
+	Remove    []string               `yaml:"remove,omitempty" json:"remove,omitempty"`       // 转发前删除的字段
+}
+
+// CompatProfile 兼容性配置，在转发前把请求调整为后端能接受的形式
+type CompatProfile struct {
+	Roles  map[string]string     `yaml:"roles,omitempty" json:"roles,omitempty"`   // 消息角色映射，如 developer: system
+	Rename map[string]string     `yaml:"rename,omitempty" json:"rename,omitempty"` // 字段改名，如 max_completion_tokens: max_tokens
+	Drop   []string              `yaml:"drop,omitempty" json:"drop,omitempty"`     // 删除的字段
+	Clamp  map[string]ValueRange `yaml:"clamp,omitempty" json:"clamp,omitempty"`   // 数值字段的取值范围
+}
+
+// ValueRange 数值范围，未设置的一端不限制
+type ValueRange struct {
+	Min *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+}
+
+// SimulateConfig 模拟流式输出配置
+type SimulateConfig struct {
+	ChunkSize int           `yaml:"chunk_size,omitempty" json:"chunk_size,omitempty"` // 每块字符数，默认4
+	Interval  time.Duration `yaml:"interval,omitempty" json:"interval,omitempty"`     // 块之间的间隔，如 "20ms"，默认不等待
 }
 
 // Server 配置结构
@@ -16,9 +117,106 @@ type Server struct {
 	Debug bool `yaml:"debug" json:"debug"`
 }
 
+// Failover 故障转移配置
+type Failover struct {
+	// StatusCodes 触发切换到下一个后端的状态码，默认 429/500/502/503/504
+	StatusCodes []int `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`
+}
+
+// CircuitBreaker 熔断器配置，连续失败或错误率任一达到阈值时熔断
+type CircuitBreaker struct {
+	ConsecutiveFailures int           `yaml:"consecutive_failures,omitempty" json:"consecutive_failures,omitempty"` // 连续失败次数阈值，0表示不启用
+	ErrorRate           float64       `yaml:"error_rate,omitempty" json:"error_rate,omitempty"`                     // 窗口内错误率阈值(0-1]，0表示不启用
+	Window              int           `yaml:"window,omitempty" json:"window,omitempty"`                             // 计算错误率的最近请求数，默认20
+	MinRequests         int           `yaml:"min_requests,omitempty" json:"min_requests,omitempty"`                 // 窗口内请求数达到后才按错误率判断，默认10
+	OpenDuration        time.Duration `yaml:"open_duration,omitempty" json:"open_duration,omitempty"`               // 熔断后进入半开状态前的等待时间，默认30s
+}
+
+// RetryPolicy 同一后端的重试策略，等待时间按 base_delay*2^(n-1) 递增，不超过max_delay
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"` // 总尝试次数（含首次），小于2表示不重试
+	BaseDelay   time.Duration `yaml:"base_delay,omitempty" json:"base_delay,omitempty"`     // 首次重试前的等待时间，默认500ms
+	MaxDelay    time.Duration `yaml:"max_delay,omitempty" json:"max_delay,omitempty"`       // 单次等待上限，默认10s；Retry-After超过上限时不再重试
+	Jitter      *float64      `yaml:"jitter,omitempty" json:"jitter,omitempty"`             // 随机缩短等待时间的比例[0-1]，未设置时为0.2，0表示不随机
+	StatusCodes []int         `yaml:"status_codes,omitempty" json:"status_codes,omitempty"` // 可重试的状态码，默认 429/502/503/504
+}
+
+// BreakerStatus 单个后端的熔断器状态
+type BreakerStatus struct {
+	State               string    `json:"state"` // closed, open, half_open
+	Since               time.Time `json:"since"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// BreakerStateFile 代理进程写出的熔断器状态快照，供CLI读取
+type BreakerStateFile struct {
+	UpdatedAt time.Time                `json:"updated_at"`
+	Backends  map[string]BreakerStatus `json:"backends"`
+}
+
+// UsageRecord 单个请求的token用量
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Client           string    `json:"client"`       // X-Client-Name请求头，未设置时为客户端IP
+	Backend          string    `json:"backend"`      // 处理请求的后端名称
+	Model            string    `json:"model"`        // 客户端请求的模型ID
+	TargetModel      string    `json:"target_model"` // 后端的模型ID
+	Path             string    `json:"path"`
+	Stream           bool      `json:"stream"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"` // 包含推理token
+	ReasoningTokens  int       `json:"reasoning_tokens"`
+	CachedTokens     int       `json:"cached_tokens"` // 命中缓存的输入token，包含在prompt_tokens中
+	TotalTokens      int       `json:"total_tokens"`
+	Estimated        bool      `json:"estimated"`                 // 后端未返回用量，按本地估算
+	EstimateMethod   string    `json:"estimate_method,omitempty"` // 本地估算的方法：cl100k_base为BPE分词计数，heuristic为按字符数的启发式估算
+	Status           int       `json:"status,omitempty"`          // 请求失败时的HTTP状态码，成功时为空
+	Cost             float64   `json:"cost"`                      // 按后端价格计算的费用，未配置价格或请求失败时为0
+	Currency         string    `json:"currency,omitempty"`
+}
+
+// Route 模型路由规则，model、glob、regex、aliases 最多选一，没有when条件时必须选一
+// 命中后请求交给backend所在的模型组（custom_model_id相同的激活后端）处理
+type Route struct {
+	Model   string          `yaml:"model,omitempty" json:"model,omitempty"`     // 精确匹配的模型名
+	Glob    string          `yaml:"glob,omitempty" json:"glob,omitempty"`       // 通配符，* 匹配任意字符，? 匹配单个字符
+	Regex   string          `yaml:"regex,omitempty" json:"regex,omitempty"`     // 正则表达式，匹配完整的模型名
+	Aliases []string        `yaml:"aliases,omitempty" json:"aliases,omitempty"` // 别名列表
+	When    *RouteCondition `yaml:"when,omitempty" json:"when,omitempty"`       // 请求内容条件，带条件的规则在模型名匹配之前判断
+	Backend string          `yaml:"backend" json:"backend"`                     // 目标后端的name
+}
+
+// RouteCondition 基于请求内容的路由条件，所有设置的条件都满足时命中
+type RouteCondition struct {
+	HasImages       *bool             `yaml:"has_images,omitempty" json:"has_images,omitempty"`               // 消息中是否包含图片
+	HasTools        *bool             `yaml:"has_tools,omitempty" json:"has_tools,omitempty"`                 // 请求是否带有tools
+	Stream          *bool             `yaml:"stream,omitempty" json:"stream,omitempty"`                       // 客户端请求的stream
+	MinPromptTokens int               `yaml:"min_prompt_tokens,omitempty" json:"min_prompt_tokens,omitempty"` // 估算的输入token数下限（含）
+	MaxPromptTokens int               `yaml:"max_prompt_tokens,omitempty" json:"max_prompt_tokens,omitempty"` // 估算的输入token数上限（含）
+	Headers         map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`                     // 请求头的值，必须完全相同
+}
+
 // Config 完整配置结构
 type Config struct {
-	Domain string `yaml:"domain" json:"domain"`
-	APIs   []API  `yaml:"apis" json:"apis"`
-	Server Server `yaml:"server" json:"server"`
+	Domain   string   `yaml:"domain" json:"domain"`
+	APIs     []API    `yaml:"apis" json:"apis"`
+	Server   Server   `yaml:"server" json:"server"`
+	Failover Failover `yaml:"failover,omitempty" json:"failover,omitempty"`
+	// LoadBalancing 按自定义模型ID配置负载均衡策略: round_robin, weighted_random, least_in_flight
+	LoadBalancing map[string]string `yaml:"load_balancing,omitempty" json:"load_balancing,omitempty"`
+	// CircuitBreaker 全局熔断器配置，未设置时不启用熔断
+	CircuitBreaker *CircuitBreaker `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// Retry 全局重试策略，未设置时不重试
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// Routes 模型路由规则，按顺序匹配，第一条命中的规则生效
+	Routes []Route `yaml:"routes,omitempty" json:"routes,omitempty"`
+	// DefaultBackend 模型名未匹配任何后端和规则时使用的后端name，其模型组中没有激活的后端时返回503；未设置时使用第一个激活的后端
+	DefaultBackend string `yaml:"default_backend,omitempty" json:"default_backend,omitempty"`
+	// Strict 严格模式，模型名未匹配时返回404 model_not_found而不是回退到默认后端
+	Strict bool `yaml:"strict,omitempty" json:"strict,omitempty"`
+	// CompatProfiles 自定义兼容性配置，同名时覆盖内置配置
+	CompatProfiles map[string]CompatProfile `yaml:"compat_profiles,omitempty" json:"compat_profiles,omitempty"`
+	// TokenizerFile 本地token估算使用的tiktoken格式cl100k_base词表，相对路径相对于配置文件所在目录
+	// 未设置时使用配置文件目录下的cl100k_base.tiktoken，文件不存在时按字符数启发式估算
+	TokenizerFile string `yaml:"tokenizer_file,omitempty" json:"tokenizer_file,omitempty"`
 }